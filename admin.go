@@ -0,0 +1,129 @@
+package stonecutters
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+)
+
+// OrphanedKeys returns the keys under prefix which have no live lease
+// attached; either they were written without one or the attached lease is
+// no longer known to etcd. Orphaned keys are never freed on their own and
+// are left for an admin to clean up. Persistent claims, see
+// ClaimPersistent, are leaseless so always reported.
+func OrphanedKeys(c *clientv3.Client, ctx context.Context, prefix string) ([]string, error) {
+	got, err := c.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	orphans := make([]string, 0)
+	alive := make(map[int64]bool)
+
+	for _, kv := range got.Kvs {
+		if kv.Lease == 0 {
+			orphans = append(orphans, string(kv.Key))
+			continue
+		}
+		live, checked := alive[kv.Lease]
+		if !checked {
+			live, err = leaseAlive(c, ctx, clientv3.LeaseID(kv.Lease))
+			if err != nil {
+				return nil, err
+			}
+			alive[kv.Lease] = live
+		}
+		if !live {
+			orphans = append(orphans, string(kv.Key))
+		}
+	}
+	return orphans, nil
+}
+
+// leaseAlive returns true if etcd still holds the lease with time remaining.
+func leaseAlive(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID) (bool, error) {
+	ttl, err := c.TimeToLive(ctx, leaseID)
+	if err == rpctypes.ErrLeaseNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return ttl.TTL > 0, nil
+}
+
+// ReapOptions selects the members Reap frees. A member matching either
+// DeadOwners or MaxAge is reaped.
+type ReapOptions struct {
+	// DeadOwners are owner names (the Identity Name) known never to renew.
+	DeadOwners []string
+	// MaxAge reaps members whose Identity started longer ago than this.
+	// Members written without an Identity Since are never reaped by age.
+	MaxAge time.Duration
+	// DryRun reports what would be reaped without revoking anything.
+	DryRun bool
+}
+
+// Reap revokes the lease of every member among ids matching the options,
+// freeing ids held by owners which crashed while their lease lives on.
+// Revoking a lease frees every id claimed under it, including any outside
+// of ids. Matching members without a lease have their key deleted. Returns
+// the members reaped, or which would be on a DryRun, along with a
+// MultiError of any leases which couldn't be revoked.
+//
+// Honours the options of MembersContext.
+func Reap(c *clientv3.Client, ctx context.Context, ids []string, ro ReapOptions, opts ...Option) ([]*Member, error) {
+	o := newOptions(opts)
+	members, err := MembersContext(c, ctx, ids, opts...)
+	if err != nil {
+		return nil, err
+	}
+	dead := make(map[string]bool, len(ro.DeadOwners))
+	for _, name := range ro.DeadOwners {
+		dead[name] = true
+	}
+
+	reaped := make([]*Member, 0)
+	revoked := make(map[clientv3.LeaseID]error)
+	var errs MultiError
+	for _, m := range members {
+		ident, err := m.Identity()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		old := ro.MaxAge > 0 && !ident.Since.IsZero() && o.clock.Now().Sub(ident.Since) > ro.MaxAge
+		if !dead[ident.Name] && !old {
+			continue
+		}
+		if ro.DryRun {
+			reaped = append(reaped, m)
+			continue
+		}
+		if m.LeaseID == 0 {
+			// nothing to revoke; free the id itself
+			if _, err := ReleaseIf(c, ctx, 0, m.Key, m.Value); err != nil {
+				errs = append(errs, fmt.Errorf("lock: releasing %s: %v", m.Key, err))
+			} else {
+				reaped = append(reaped, m)
+			}
+			continue
+		}
+		rerr, done := revoked[m.LeaseID]
+		if !done {
+			rerr = revokeLease(c, ctx, m.LeaseID)
+			revoked[m.LeaseID] = rerr
+			if rerr != nil {
+				errs = append(errs, fmt.Errorf("lock: revoking lease %x of %s: %v", m.LeaseID, m.Key, rerr))
+			}
+		}
+		if rerr == nil {
+			reaped = append(reaped, m)
+		}
+	}
+	if len(errs) > 0 {
+		return reaped, errs
+	}
+	return reaped, nil
+}
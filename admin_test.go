@@ -0,0 +1,114 @@
+package stonecutters
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestOrphanedKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prefix := "/orphans/"
+	defer client.Delete(ctx, prefix, clientv3.WithPrefix())
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	if _, err := kvPutLease(client, ctx, lease.ID, prefix+"leased", "hihi"); err != nil {
+		t.Fatalf("error putting leased key: %v", err)
+	}
+	if _, err := client.Put(ctx, prefix+"leaseless", "hihi"); err != nil {
+		t.Fatalf("error putting leaseless key: %v", err)
+	}
+
+	orphans, err := OrphanedKeys(client, ctx, prefix)
+	if err != nil {
+		t.Fatalf("error listing orphans: %v", err)
+	}
+	if len(orphans) != 1 || orphans[0] != prefix+"leaseless" {
+		t.Errorf("only %q should be orphaned; not: %v", prefix+"leaseless", orphans)
+	}
+}
+
+func TestReap(t *testing.T) {
+	ids := PrefixedNumerics("/reap/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dead, err := client.Grant(ctx, int64(30))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, dead.ID)
+	alive, err := client.Grant(ctx, int64(30))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, alive.ID)
+
+	if _, err := Join(client, ctx, dead.ID, "hihi-dead", ids); err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	old := NewIdentity("hihi-old")
+	old.Since = time.Now().Add(-time.Hour)
+	if _, err := JoinAs(client, ctx, alive.ID, old, ids); err != nil {
+		t.Fatalf("JoinAs err: %v", err)
+	}
+	if _, err := JoinAs(client, ctx, alive.ID, NewIdentity("hihi-alive"), ids); err != nil {
+		t.Fatalf("JoinAs err: %v", err)
+	}
+
+	ro := ReapOptions{DeadOwners: []string{"hihi-dead"}, DryRun: true}
+	preview, err := Reap(client, ctx, ids, ro)
+	if err != nil {
+		t.Fatalf("Reap err: %v", err)
+	}
+	if len(preview) != 1 || preview[0].Key != ids[0] {
+		t.Errorf("dry run should preview reaping %s; not: %v", ids[0], preview)
+	}
+	if members, _ := Members(client, ids); len(members) != 3 {
+		t.Errorf("dry run should not free anything; members: %d", len(members))
+	}
+
+	ro.DryRun = false
+	reaped, err := Reap(client, ctx, ids, ro)
+	if err != nil {
+		t.Fatalf("Reap err: %v", err)
+	}
+	if len(reaped) != 1 || reaped[0].Key != ids[0] {
+		t.Errorf("reap should free %s; freed: %v", ids[0], reaped)
+	}
+	members, err := Members(client, ids)
+	if err != nil {
+		t.Fatalf("error listing members: %v", err)
+	}
+	if len(members) != 2 {
+		t.Errorf("members returned should be 2; not: %d", len(members))
+	}
+
+	// MaxAge picks out the old identity, which shares its lease with the live one
+	reaped, err = Reap(client, ctx, ids, ReapOptions{MaxAge: time.Minute, DryRun: true})
+	if err != nil {
+		t.Fatalf("Reap err: %v", err)
+	}
+	if len(reaped) != 1 || reaped[0].Key != ids[1] {
+		t.Errorf("max age should pick out %s; picked: %v", ids[1], reaped)
+	}
+
+	// a day on, the other Identity is old too
+	clk := newFakeClock()
+	clk.now = time.Now().Add(24 * time.Hour)
+	reaped, err = Reap(client, ctx, ids, ReapOptions{MaxAge: time.Hour, DryRun: true}, withClock(clk))
+	if err != nil {
+		t.Fatalf("Reap err: %v", err)
+	}
+	if len(reaped) != 2 {
+		t.Errorf("max age should go by the clock; picked: %v", reaped)
+	}
+}
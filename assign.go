@@ -0,0 +1,111 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// ClaimAssignments claims each id of the assignments, a map of id to owner
+// value, under the lease, for a controller placing specific workers on
+// specific ids. Each id is claimed only if unclaimed, and independently of
+// the others: unlike ClaimQuorum partial success is expected, and nothing
+// is released when some ids are contended.
+//
+// claimed and contended list the ids claimed and those already held,
+// sorted. Ids which failed for another reason are in neither, and err is a
+// MultiError of those failures.
+func ClaimAssignments(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	assignments map[string]string) (claimed, contended []string, err error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, nil, err
+	}
+	ids := make([]string, 0, len(assignments))
+	for id := range assignments {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var errs MultiError
+	for _, id := range ids {
+		_, perr := kvPutLease(c, ctx, leaseID, id, assignments[id])
+		switch {
+		case perr == nil:
+			claimed = append(claimed, id)
+		case perr == PutSucceededFailure:
+			contended = append(contended, id)
+		default:
+			if cerr := checkContext(ctx); cerr != nil {
+				errs = append(errs, cerr)
+				return claimed, contended, errs
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", id, authError(perr)))
+		}
+	}
+	if len(errs) > 0 {
+		return claimed, contended, errs
+	}
+	return claimed, contended, nil
+}
+
+// ClaimOutcome is the result of claiming one id of a GetIDs call: Outcome
+// is AttemptClaimed with the Member, AttemptConflict if the id was already
+// held, AttemptFiltered if WithFilter rejected it, or AttemptError with
+// the error.
+type ClaimOutcome struct {
+	Outcome Outcome
+	Member  *Member // set for AttemptClaimed
+	Err     error   // set for AttemptError
+}
+
+// GetIDs tries to claim every id under the lease, independently of the
+// others as ClaimAssignments does, and returns the outcome for each id
+// requested. Partial success is expected and nothing claimed is released;
+// see ClaimQuorum for all or most of the ids or nothing.
+//
+// err is only set when claiming can't go on, the context closed or the
+// credentials expired; the ids not yet tried are then reported with that
+// error.
+//
+// Honours WithFilter and WithConditions.
+func GetIDs(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	name string, ids []string, opts ...Option) (map[string]ClaimOutcome, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	outcomes := make(map[string]ClaimOutcome, len(ids))
+	for i, id := range ids {
+		if o.filter != nil && !o.filter(id) {
+			outcomes[id] = ClaimOutcome{Outcome: AttemptFiltered}
+			continue
+		}
+		txn, err := kvPutLease(c, ctx, leaseID, id, name, o.conditions...)
+		switch {
+		case err == nil:
+			outcomes[id] = ClaimOutcome{Outcome: AttemptClaimed,
+				Member: &Member{Key: id, Value: name, LeaseID: leaseID, CreateRevision: txn.Header.Revision}}
+		case err == PutSucceededFailure:
+			outcomes[id] = ClaimOutcome{Outcome: AttemptConflict}
+		default:
+			fatal := checkContext(ctx)
+			if fatal == nil && errors.Is(authError(err), ErrAuthExpired) {
+				fatal = authError(err)
+			}
+			if fatal == nil {
+				outcomes[id] = ClaimOutcome{Outcome: AttemptError, Err: err}
+				continue
+			}
+			for _, rest := range ids[i:] {
+				if _, ok := outcomes[rest]; !ok {
+					outcomes[rest] = ClaimOutcome{Outcome: AttemptError, Err: fatal}
+				}
+			}
+			return outcomes, fatal
+		}
+	}
+	return outcomes, nil
+}
@@ -0,0 +1,83 @@
+package stonecutters
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestClaimAssignments(t *testing.T) {
+	ids := PrefixedNumerics("/assign/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	if _, err := kvPutLease(client, ctx, lease.ID, ids[1], "hihi-squatter"); err != nil {
+		t.Fatalf("error holding %s: %v", ids[1], err)
+	}
+
+	assignments := map[string]string{ids[0]: "worker-a", ids[1]: "worker-b", ids[2]: "worker-c"}
+	claimed, contended, err := ClaimAssignments(client, ctx, lease.ID, assignments)
+	if err != nil {
+		t.Fatalf("ClaimAssignments err: %v", err)
+	}
+	if len(claimed) != 2 || claimed[0] != ids[0] || claimed[1] != ids[2] {
+		t.Errorf("claimed should be %s and %s; not: %v", ids[0], ids[2], claimed)
+	}
+	if len(contended) != 1 || contended[0] != ids[1] {
+		t.Errorf("contended should be %s; not: %v", ids[1], contended)
+	}
+
+	members, err := Members(client, ids)
+	if err != nil {
+		t.Fatalf("error listing members: %v", err)
+	}
+	for _, m := range members {
+		if m.Key != ids[1] && m.Value != assignments[m.Key] {
+			t.Errorf("%s should be assigned %s; not: %s", m.Key, assignments[m.Key], m.Value)
+		}
+	}
+}
+
+func TestGetIDs(t *testing.T) {
+	ids := PrefixedNumerics("/getids/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	if _, err := kvPutLease(client, ctx, lease.ID, ids[1], "hihi-squatter"); err != nil {
+		t.Fatalf("error holding %s: %v", ids[1], err)
+	}
+	tooBig := strings.Repeat("x", maxIdSize+1) // etcd rejects the request
+	requested := append(ids, tooBig)
+	skipLast := WithFilter(func(id string) bool { return id != ids[2] })
+	outcomes, err := GetIDs(client, ctx, lease.ID, "hihi", requested, skipLast)
+	if err != nil {
+		t.Fatalf("GetIDs err: %v", err)
+	}
+	if len(outcomes) != len(requested) {
+		t.Fatalf("every id requested should have an outcome: %v", outcomes)
+	}
+	if o := outcomes[ids[0]]; o.Outcome != AttemptClaimed || o.Member == nil || o.Member.Key != ids[0] {
+		t.Errorf("%s should be claimed: %+v", ids[0], o)
+	}
+	if o := outcomes[ids[1]]; o.Outcome != AttemptConflict {
+		t.Errorf("%s should be taken: %+v", ids[1], o)
+	}
+	if o := outcomes[ids[2]]; o.Outcome != AttemptFiltered {
+		t.Errorf("%s should be filtered: %+v", ids[2], o)
+	}
+	if o := outcomes[tooBig]; o.Outcome != AttemptError || o.Err == nil {
+		t.Errorf("oversized id should have errored: %v %v", o.Outcome, o.Err)
+	}
+}
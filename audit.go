@@ -0,0 +1,67 @@
+package stonecutters
+
+import (
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// AuditAction is what happened to a claim in an AuditEvent.
+type AuditAction int
+
+const (
+	AuditClaimed  AuditAction = iota
+	AuditReleased             // by its holder
+	AuditLost                 // dropped by a Session, or a Locker's lease ended
+)
+
+func (a AuditAction) String() string {
+	switch a {
+	case AuditClaimed:
+		return "claimed"
+	case AuditReleased:
+		return "released"
+	case AuditLost:
+		return "lost"
+	}
+	return "unknown"
+}
+
+// AuditEvent records one claim or release: who did what to which id, when,
+// under which lease.
+type AuditEvent struct {
+	Action  AuditAction
+	ID      string // the id, see WithKeyTransform
+	Key     string // the etcd key
+	Holder  string // the claim's value
+	LeaseID clientv3.LeaseID
+	At      time.Time
+}
+
+// AuditSink receives an AuditEvent for every claim and release made by
+// the calls it is given to, eg to write a compliance log or publish to an
+// event bus. Unlike Metrics it sees each event individually. Record is
+// called synchronously once the claim or release has succeeded, so should
+// hand the event off rather than block, and be safe for concurrent use.
+type AuditSink interface {
+	Record(ev AuditEvent)
+}
+
+// WithAuditSink records the claims and releases of the call to sink. Join
+// and the calls built on it record claims; ReleaseIf, Release, and the
+// releases of a Session or Locker record releases, and a Session its lost
+// claims too. Unset by default, at no cost.
+func WithAuditSink(sink AuditSink) Option {
+	return func(o *options) {
+		o.audit = sink
+	}
+}
+
+// audited records the action on m, if an AuditSink is set.
+func (o *options) audited(action AuditAction, m *Member) {
+	if o == nil || o.audit == nil {
+		return
+	}
+	o.audit.Record(AuditEvent{Action: action, ID: m.id(), Key: m.Key, Holder: m.Value,
+		LeaseID: m.LeaseID, At: o.clock.Now()})
+}
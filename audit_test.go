@@ -0,0 +1,121 @@
+package stonecutters
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+type recordingAudit struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (r *recordingAudit) Record(ev AuditEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+func (r *recordingAudit) Events() []AuditEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]AuditEvent(nil), r.events...)
+}
+
+func TestAuditSink(t *testing.T) {
+	ids := PrefixedNumerics("/audit/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sink := &recordingAudit{}
+	audit := WithAuditSink(sink)
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	m, err := Join(client, ctx, lease.ID, "hihi", ids, audit)
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if _, err := ReleaseIf(client, ctx, lease.ID, m.Key, m.Value, audit); err != nil {
+		t.Fatalf("ReleaseIf err: %v", err)
+	}
+	other, err := Join(client, ctx, lease.ID, "hihi-other", ids)
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if err := Release(client, ctx, other.Key, audit); err != nil {
+		t.Fatalf("Release err: %v", err)
+	}
+	s := NewSession(client)
+	defer s.Close()
+	cl, err := s.Claim(ctx, "hihi-session", ids, 5, audit)
+	if err != nil {
+		t.Fatalf("Claim err: %v", err)
+	}
+	if err := s.Release(ctx, cl.Key); err != nil {
+		t.Fatalf("Release err: %v", err)
+	}
+
+	want := []struct {
+		action AuditAction
+		holder string
+	}{
+		{AuditClaimed, "hihi"},
+		{AuditReleased, "hihi"},
+		{AuditReleased, "hihi-other"}, // its claim wasn't audited
+		{AuditClaimed, "hihi-session"},
+		{AuditReleased, "hihi-session"},
+	}
+	events := sink.Events()
+	if len(events) != len(want) {
+		t.Fatalf("should record %d events; recorded: %+v", len(want), events)
+	}
+	for i, w := range want {
+		ev := events[i]
+		if ev.Action != w.action || ev.Holder != w.holder || ev.Key != ids[0] || ev.At.IsZero() {
+			t.Errorf("event %d should be %v of %s by %s: %+v", i, w.action, ids[0], w.holder, ev)
+		}
+	}
+	if events[0].LeaseID != lease.ID || events[4].LeaseID != cl.LeaseID {
+		t.Errorf("events should record their lease: %+v", events)
+	}
+}
+
+func TestAuditSinkLockerLost(t *testing.T) {
+	ids := PrefixedNumerics("/audit-lost/", 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sink := &recordingAudit{}
+	l := NewLocker(client, ids, WithAuditSink(sink))
+	id, release, err := l.Acquire(ctx, "hihi")
+	if err != nil {
+		t.Fatalf("Acquire err: %v", err)
+	}
+	defer release()
+	got, err := client.Get(ctx, id)
+	if err != nil || len(got.Kvs) != 1 {
+		t.Fatalf("%s should be claimed: %v %v", id, got, err)
+	}
+	if _, err := client.Revoke(ctx, clientv3.LeaseID(got.Kvs[0].Lease)); err != nil {
+		t.Fatalf("error revoking lease: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.Events()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the revoked claim should be audited; recorded: %+v", sink.Events())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if ev := sink.Events()[1]; ev.Action != AuditLost || ev.Key != id {
+		t.Errorf("revoking the lease should audit %s lost: %+v", id, ev)
+	}
+}
@@ -0,0 +1,134 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+var (
+	SlotsFullFailure   = errors.New("lock: every slot of the bitmap is claimed; slots are leaseless and only freed by ReleaseSlot")
+	SlotNotHeldFailure = errors.New("lock: slot is not claimed in the bitmap")
+)
+
+// ClaimSlot claims the lowest free slot of the 'size' slots tracked by the
+// bitmap stored at key, and returns its index. A dense pool of thousands of
+// numeric ids then costs a single key rather than a key per id. The bitmap
+// is updated with a compare-and-swap on the key's revision, retried on
+// contention until the context is closed.
+//
+// Unlike Join, slots aren't bound to a lease: a slot claimed by a process
+// which crashes stays claimed until ReleaseSlot is called for it, eg by a
+// reconciler comparing ClaimedSlots to the live processes. Returns
+// SlotsFullFailure when every slot is claimed.
+func ClaimSlot(c *clientv3.Client, ctx context.Context, key string, size int) (int, error) {
+	for {
+		if err := checkContext(ctx); err != nil {
+			return -1, err
+		}
+		bits, rev, err := getBitmap(c, ctx, key)
+		if err != nil {
+			return -1, err
+		}
+		slot := -1
+		for i := 0; i < size; i++ {
+			if !bitSet(bits, i) {
+				slot = i
+				break
+			}
+		}
+		if slot < 0 {
+			return -1, SlotsFullFailure
+		}
+		bits = setBit(bits, slot, true)
+		if ok, err := putBitmap(c, ctx, key, bits, rev); err != nil {
+			return -1, err
+		} else if ok {
+			return slot, nil
+		}
+		// changed since read, try again
+	}
+}
+
+// ReleaseSlot frees a slot claimed with ClaimSlot, or returns
+// SlotNotHeldFailure if it isn't claimed. The bitmap doesn't record owners,
+// so it is up to the caller to only release its own slot.
+func ReleaseSlot(c *clientv3.Client, ctx context.Context, key string, slot int) error {
+	for {
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+		bits, rev, err := getBitmap(c, ctx, key)
+		if err != nil {
+			return err
+		}
+		if !bitSet(bits, slot) {
+			return fmt.Errorf("%w: %d", SlotNotHeldFailure, slot)
+		}
+		bits = setBit(bits, slot, false)
+		if ok, err := putBitmap(c, ctx, key, bits, rev); err != nil || ok {
+			return err
+		}
+	}
+}
+
+// ClaimedSlots returns the claimed slots of the bitmap at key, in order.
+func ClaimedSlots(c *clientv3.Client, ctx context.Context, key string) ([]int, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	bits, _, err := getBitmap(c, ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	slots := make([]int, 0)
+	for i := 0; i < len(bits)*8; i++ {
+		if bitSet(bits, i) {
+			slots = append(slots, i)
+		}
+	}
+	return slots, nil
+}
+
+// getBitmap reads the bitmap and the ModRevision to swap it at, zero when
+// the key doesn't exist yet.
+func getBitmap(c *clientv3.Client, ctx context.Context, key string) ([]byte, int64, error) {
+	got, err := c.Get(ctx, key)
+	if err != nil {
+		return nil, 0, authError(err)
+	}
+	if len(got.Kvs) == 0 {
+		return nil, 0, nil
+	}
+	return got.Kvs[0].Value, got.Kvs[0].ModRevision, nil
+}
+
+// putBitmap writes the bitmap if the key is unchanged since rev.
+func putBitmap(c *clientv3.Client, ctx context.Context, key string, bits []byte, rev int64) (bool, error) {
+	resp, err := c.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).
+		Then(clientv3.OpPut(key, string(bits))).
+		Commit()
+	if err != nil {
+		return false, authError(err)
+	}
+	return resp.Succeeded, nil
+}
+
+func bitSet(bits []byte, i int) bool {
+	return i/8 < len(bits) && bits[i/8]&(1<<uint(i%8)) != 0
+}
+
+func setBit(bits []byte, i int, on bool) []byte {
+	for len(bits) <= i/8 {
+		bits = append(bits, 0)
+	}
+	if on {
+		bits[i/8] |= 1 << uint(i%8)
+	} else {
+		bits[i/8] &^= 1 << uint(i%8)
+	}
+	return bits
+}
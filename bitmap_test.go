@@ -0,0 +1,61 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestClaimSlot(t *testing.T) {
+	key := "/bitmap/workers"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// claimants racing over the bitmap each get a distinct slot
+	size := 20
+	slots := make(chan int, size)
+	var wg sync.WaitGroup
+	for i := 0; i < size; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slot, err := ClaimSlot(client, ctx, key, size)
+			if err != nil {
+				t.Errorf("ClaimSlot err: %v", err)
+				return
+			}
+			slots <- slot
+		}()
+	}
+	wg.Wait()
+	close(slots)
+	seen := make(map[int]bool)
+	for slot := range slots {
+		if seen[slot] || slot < 0 || slot >= size {
+			t.Errorf("slot %d claimed twice or out of range", slot)
+		}
+		seen[slot] = true
+	}
+
+	if _, err := ClaimSlot(client, ctx, key, size); err != SlotsFullFailure {
+		t.Errorf("err[%v] should be SlotsFullFailure", err)
+	}
+	if err := ReleaseSlot(client, ctx, key, 7); err != nil {
+		t.Fatalf("ReleaseSlot err: %v", err)
+	}
+	if err := ReleaseSlot(client, ctx, key, 7); !errors.Is(err, SlotNotHeldFailure) {
+		t.Errorf("err[%v] should be SlotNotHeldFailure", err)
+	}
+	if slot, err := ClaimSlot(client, ctx, key, size); err != nil || slot != 7 {
+		t.Errorf("the released slot 7 should be claimed next; got: %d %v", slot, err)
+	}
+
+	claimed, err := ClaimedSlots(client, ctx, key)
+	if err != nil {
+		t.Fatalf("ClaimedSlots err: %v", err)
+	}
+	if len(claimed) != size {
+		t.Errorf("every slot should be claimed; got: %v", claimed)
+	}
+}
@@ -0,0 +1,91 @@
+package stonecutters
+
+var defaultStateBuffer = 8
+
+// ClaimState is a stage in the lifecycle of a Claim.
+type ClaimState int
+
+const (
+	ClaimPending  ClaimState = iota // being claimed
+	ClaimClaimed                    // held
+	ClaimRenewing                   // held, its renewal in flight
+	ClaimLost                       // lost to lease expiry or another owner; final
+	ClaimReleased                   // released by the Session; final
+)
+
+func (st ClaimState) String() string {
+	switch st {
+	case ClaimPending:
+		return "pending"
+	case ClaimClaimed:
+		return "claimed"
+	case ClaimRenewing:
+		return "renewing"
+	case ClaimLost:
+		return "lost"
+	case ClaimReleased:
+		return "released"
+	}
+	return "unknown"
+}
+
+func (st ClaimState) final() bool {
+	return st == ClaimLost || st == ClaimReleased
+}
+
+// State returns the claim's current state.
+func (cl *Claim) State() ClaimState {
+	cl.smu.Lock()
+	defer cl.smu.Unlock()
+	return cl.state
+}
+
+// States emits each transition of the claim's state, starting from
+// ClaimPending, and is closed after the final ClaimLost or ClaimReleased.
+// It is buffered; should the reader fall behind the oldest transitions are
+// dropped, never the latest.
+func (cl *Claim) States() <-chan ClaimState {
+	return cl.states
+}
+
+// setState moves the claim to st, unless it has already reached a final
+// state.
+func (cl *Claim) setState(st ClaimState) {
+	cl.smu.Lock()
+	defer cl.smu.Unlock()
+	if cl.state.final() || cl.state == st {
+		return
+	}
+	cl.state = st
+	cl.emit(st)
+	if st.final() {
+		close(cl.states)
+	}
+}
+
+// emit sends st on the states channel, making room for it if the reader
+// fell behind. Called with smu held, the only sender.
+func (cl *Claim) emit(st ClaimState) {
+	for {
+		select {
+		case cl.states <- st:
+			return
+		default:
+		}
+		select {
+		case <-cl.states:
+		default:
+		}
+	}
+}
+
+// newClaim returns a pending claim of the member.
+func newClaim(m *Member) *Claim {
+	cl := &Claim{
+		Member: *m,
+		done:   make(chan struct{}),
+		states: make(chan ClaimState, defaultStateBuffer),
+	}
+	cl.emit(ClaimPending)
+	return cl
+}
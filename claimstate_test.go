@@ -0,0 +1,69 @@
+package stonecutters
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// nextState returns the next transition of the claim, or fails the test.
+func nextState(t *testing.T, cl *Claim) (ClaimState, bool) {
+	t.Helper()
+	select {
+	case st, ok := <-cl.States():
+		return st, ok
+	case <-time.After(3 * time.Second):
+		t.Fatalf("no transition of %s; state is %s", cl.Key, cl.State())
+	}
+	return 0, false
+}
+
+func TestClaimStates(t *testing.T) {
+	ids := PrefixedNumerics("/states/", 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSession(client)
+	defer s.Close()
+	lost, err := s.Claim(ctx, "hihi", ids, 3)
+	if err != nil {
+		t.Fatalf("Claim err: %v", err)
+	}
+	released, err := s.Claim(ctx, "hihi", ids, 3)
+	if err != nil {
+		t.Fatalf("Claim err: %v", err)
+	}
+
+	for _, want := range []ClaimState{ClaimPending, ClaimClaimed, ClaimRenewing, ClaimClaimed} {
+		if st, _ := nextState(t, lost); st != want {
+			t.Fatalf("state should be %s; not: %s", want, st)
+		}
+	}
+
+	expireLease(t, lost.LeaseID)
+	for {
+		st, ok := nextState(t, lost)
+		if !ok {
+			break
+		}
+		if st == ClaimLost {
+			continue
+		} else if st != ClaimRenewing && st != ClaimClaimed {
+			t.Errorf("unexpected transition before loss: %s", st)
+		}
+	}
+	if lost.State() != ClaimLost {
+		t.Errorf("expired claim should be lost; not: %s", lost.State())
+	}
+
+	if err := s.Release(ctx, released.Key); err != nil {
+		t.Fatalf("Release err: %v", err)
+	}
+	var last ClaimState
+	for st := range released.States() {
+		last = st
+	}
+	if last != ClaimReleased || released.State() != ClaimReleased {
+		t.Errorf("released claim should end released; not: %s", last)
+	}
+}
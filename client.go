@@ -0,0 +1,121 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+)
+
+var (
+	defaultDialTimeout = 5 * time.Second
+	ErrAuthExpired     = errors.New("lock: etcd auth token expired and could not be refreshed")
+)
+
+// ClientConfig holds the settings used by NewClient to build an etcd client.
+type ClientConfig struct {
+	Endpoints   []string
+	DialTimeout time.Duration // defaults to 5s
+
+	// Username and Password authenticate against a cluster with auth
+	// enabled. The client fetches a fresh token with them whenever etcd
+	// reports the current one expired; only if that fails do calls return
+	// ErrAuthExpired.
+	Username string
+	Password string
+
+	// HealthProbeInterval enables endpoint health probing when non-zero.
+	// See PreferHealthyEndpoints.
+	HealthProbeInterval time.Duration
+}
+
+// NewClient creates an etcd client from the config. When a
+// HealthProbeInterval is set the client's endpoints are re-probed on that
+// interval until the client is closed.
+func NewClient(cfg ClientConfig) (*clientv3.Client, error) {
+	dt := cfg.DialTimeout
+	if dt == 0 {
+		dt = defaultDialTimeout
+	}
+	c, err := clientv3.New(clientv3.Config{
+		Endpoints:   cfg.Endpoints,
+		DialTimeout: dt,
+		Username:    cfg.Username,
+		Password:    cfg.Password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.HealthProbeInterval > 0 {
+		go PreferHealthyEndpoints(c, c.Ctx(), cfg.HealthProbeInterval)
+	}
+	return c, nil
+}
+
+// PreferHealthyEndpoints probes every endpoint the client was configured
+// with on each interval and points the client at the ones answering a
+// Status request, so claims route around a dead member rather than waiting
+// on it. If no endpoint answers the full list is restored and left to the
+// client's own balancer. Blocks until the context is closed.
+func PreferHealthyEndpoints(c *clientv3.Client, ctx context.Context, interval time.Duration) {
+	all := c.Endpoints()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		healthy := healthyEndpoints(c, ctx, all, interval)
+		if len(healthy) == 0 {
+			healthy = all
+		}
+		c.SetEndpoints(healthy...)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// healthyEndpoints returns the endpoints which answered a Status request
+// within the timeout, in their configured order.
+func healthyEndpoints(c *clientv3.Client, ctx context.Context, endpoints []string, timeout time.Duration) []string {
+	ok := make([]bool, len(endpoints))
+	var wg sync.WaitGroup
+	for i, ep := range endpoints {
+		wg.Add(1)
+		go func(i int, ep string) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			if _, err := c.Status(pctx, ep); err == nil {
+				ok[i] = true
+			}
+		}(i, ep)
+	}
+	wg.Wait()
+
+	healthy := make([]string, 0, len(endpoints))
+	for i, ep := range endpoints {
+		if ok[i] {
+			healthy = append(healthy, ep)
+		}
+	}
+	return healthy
+}
+
+// authError wraps etcd's auth failures in ErrAuthExpired so they are not
+// mistaken for contention; other errors are returned unchanged. The client
+// has already retried with a refreshed token by the time one surfaces, so
+// the caller needs to re-authenticate, eg with a new client.
+func authError(err error) error {
+	switch rpctypes.Error(err) {
+	case rpctypes.ErrInvalidAuthToken, rpctypes.ErrAuthFailed, rpctypes.ErrUserEmpty:
+		return fmt.Errorf("%w: %v", ErrAuthExpired, err)
+	}
+	return err
+}
@@ -0,0 +1,38 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+)
+
+func TestHealthyEndpoints(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := NewClient(ClientConfig{Endpoints: []string{"localhost:2379"}})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer c.Close()
+
+	eps := []string{"localhost:1", "localhost:2379"}
+	healthy := healthyEndpoints(c, ctx, eps, time.Second)
+	if len(healthy) != 1 || healthy[0] != "localhost:2379" {
+		t.Errorf("healthy endpoints should only be localhost:2379; not: %v", healthy)
+	}
+}
+
+func TestAuthError(t *testing.T) {
+	for _, err := range []error{rpctypes.ErrGRPCInvalidAuthToken, rpctypes.ErrInvalidAuthToken, rpctypes.ErrAuthFailed} {
+		if !errors.Is(authError(err), ErrAuthExpired) {
+			t.Errorf("%v should be reported as ErrAuthExpired", err)
+		}
+	}
+	if err := authError(rpctypes.ErrLeaseNotFound); err != rpctypes.ErrLeaseNotFound {
+		t.Errorf("non-auth error should be returned unchanged: %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package stonecutters
+
+import "time"
+
+// clock is the time source of the lease TTL, backoff and rate limit logic,
+// so tests can advance time deterministically rather than sleep. Real time
+// unless withClock is given.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// withClock replaces real time with the clock.
+func withClock(c clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
@@ -0,0 +1,221 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// fakeClock only moves when waited on: After advances it by the wait and
+// fires at once, recording the wait.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.waits = append(c.waits, d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func (c *fakeClock) Waits() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.waits...)
+}
+
+// manualClock only moves when advanced, firing the waits it passes.
+type manualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []manualTimer
+}
+
+type manualTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{now: time.Unix(0, 0)}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.timers = append(c.timers, manualTimer{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+func (c *manualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, tm := range c.timers {
+		if tm.at.After(c.now) {
+			pending = append(pending, tm)
+			continue
+		}
+		tm.ch <- c.now
+	}
+	c.timers = pending
+}
+
+// haltingLease fails every keepalive once halted, as a client cut off from
+// etcd would.
+type haltingLease struct {
+	clientv3.Lease
+	mu     sync.Mutex
+	halted bool
+}
+
+func (l *haltingLease) halt() {
+	l.mu.Lock()
+	l.halted = true
+	l.mu.Unlock()
+}
+
+func (l *haltingLease) KeepAliveOnce(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseKeepAliveResponse, error) {
+	l.mu.Lock()
+	halted := l.halted
+	l.mu.Unlock()
+	if halted {
+		return nil, errors.New("etcd unreachable")
+	}
+	return l.Lease.KeepAliveOnce(ctx, id)
+}
+
+func TestSessionExpiryClock(t *testing.T) {
+	ids := PrefixedNumerics("/expiry-clock/", 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := clientv3.New(clientv3.Config{Endpoints: client.Endpoints(), DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer c.Close()
+	lease := &haltingLease{Lease: c.Lease}
+	c.Lease = lease
+
+	clk := newManualClock()
+	s := NewSession(c, withClock(clk))
+	defer s.Close()
+	cl, err := s.Claim(ctx, "hihi", ids, 6)
+	if err != nil {
+		t.Fatalf("Claim err: %v", err)
+	}
+
+	// real time passing renews nothing, the loop waits on the clock
+	deadline := cl.deadline()
+	time.Sleep(2500 * time.Millisecond)
+	if cl.deadline() != deadline {
+		t.Fatalf("renewal should wait on the clock, not real time")
+	}
+
+	// cut off, renewals fail but the claim stands until its TTL passes
+	lease.halt()
+	var lost *ClaimFailure
+	for elapsed := time.Duration(0); lost == nil; {
+		clk.Advance(time.Second)
+		elapsed += time.Second
+		select {
+		case f := <-s.Failures():
+			if !f.Lost {
+				if elapsed > 6*time.Second {
+					t.Fatalf("the claim should be lost by %v: %+v", elapsed, f)
+				}
+				continue
+			}
+			if elapsed <= 6*time.Second {
+				t.Fatalf("the claim should stand for its TTL; lost after %v", elapsed)
+			}
+			lost = &f
+		case <-time.After(100 * time.Millisecond):
+		}
+		if elapsed > time.Minute {
+			t.Fatal("the claim should be lost once its TTL passed")
+		}
+	}
+	if lost.Key != cl.Key || lost.Err != LeaseLostFailure || lost.Reason != LostExpired {
+		t.Errorf("%s should be lost to expiry: %+v", cl.Key, lost)
+	}
+}
+
+func TestTokenBucketClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newFakeClock()
+	b := newTokenBucket(2, 1, clk)
+	for i := 0; i < 3; i++ {
+		if err := b.wait(ctx); err != nil {
+			t.Fatalf("wait err: %v", err)
+		}
+	}
+	// one from the burst then two at 500ms each
+	waits := clk.Waits()
+	if len(waits) != 2 || waits[0] != 500*time.Millisecond || waits[1] != 500*time.Millisecond {
+		t.Errorf("3 waits at 2/s should wait 500ms twice: %v", waits)
+	}
+}
+
+func TestJoinRetryClock(t *testing.T) {
+	ids := PrefixedNumerics("/retryclock/", 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	if _, err := Join(client, ctx, lease.ID, "other", ids); err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+
+	// an hour of backoff takes no time at all
+	clk := newFakeClock()
+	ro := RetryOptions{Attempts: 4, Policy: ExponentialBackoff{Base: 20 * time.Minute, Max: time.Hour}}
+	if _, err := JoinRetry(client, ctx, lease.ID, "hihi", ids, ro, withClock(clk)); !errors.Is(err, GetIdFailure) {
+		t.Fatalf("JoinRetry err[%v] should be GetIdFailure", err)
+	}
+	want := []time.Duration{20 * time.Minute, 40 * time.Minute, time.Hour}
+	waits := clk.Waits()
+	if len(waits) != len(want) {
+		t.Fatalf("JoinRetry should wait %v; waited: %v", want, waits)
+	}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Errorf("wait %d should be %v; not: %v", i, want[i], waits[i])
+		}
+	}
+}
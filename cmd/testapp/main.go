@@ -38,7 +38,8 @@ func main() {
 	}
 
 	// Create etcd lease keepalive
-	_, kaerr := client.KeepAlive(ctx, lease.ID)
+	session := stonecutters.NewSession(client)
+	lost, kaerr := session.KeepAlive(ctx, lease.ID)
 	if kaerr != nil {
 		log.Fatal(kaerr)
 	}
@@ -55,7 +56,10 @@ func main() {
 	// for{ print ID granted, list other members }
 	for {
 		select {
+		case err := <-lost:
+			log.Fatalf("lease lost: %v", err)
 		case <-s:
+			session.Close()
 			client.Revoke(ctx, lease.ID)
 			client.Close()
 			cancel()
@@ -0,0 +1,108 @@
+package stonecutters
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultContentionHalfLife is how long an id's contention score takes to
+// halve with no further conflicts.
+var defaultContentionHalfLife = time.Minute
+
+// ContentionStats tracks how contended each id has recently been, as seen
+// by the claims of this process. Each conflict on an id adds one to its
+// score and each successful claim of it halves the score. Scores also halve
+// every minute without a conflict, so ids which have stopped being fought
+// over recover even if, tried last, they are rarely claimed.
+type ContentionStats struct {
+	mu     sync.Mutex
+	clock  clock
+	scores map[string]contention
+}
+
+// contention is a score as of a time, from which it decays.
+type contention struct {
+	score float64
+	at    time.Time
+}
+
+// NewContentionStats returns stats which have seen no contention yet.
+func NewContentionStats(opts ...Option) *ContentionStats {
+	return &ContentionStats{clock: newOptions(opts).clock, scores: make(map[string]contention)}
+}
+
+// Record updates the stats with the outcome of an attempt, as reported
+// by WithAttemptReport.
+func (s *ContentionStats) Record(a Attempt) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	score := s.score(a.ID, now)
+	switch a.Outcome {
+	case AttemptConflict:
+		score++
+	case AttemptClaimed:
+		score /= 2
+	default:
+		return
+	}
+	if score < 0.01 {
+		delete(s.scores, a.ID)
+		return
+	}
+	s.scores[a.ID] = contention{score: score, at: now}
+}
+
+// Score returns the recent contention of id, zero if none was seen.
+func (s *ContentionStats) Score(id string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.score(id, s.clock.Now())
+}
+
+// score returns the score of id decayed to now. Called with mu held.
+func (s *ContentionStats) score(id string, now time.Time) float64 {
+	c, ok := s.scores[id]
+	if !ok {
+		return 0
+	}
+	halvings := now.Sub(c.at).Seconds() / defaultContentionHalfLife.Seconds()
+	return c.score * math.Pow(0.5, halvings)
+}
+
+// LeastContended returns a copy of ids ordered from the least to the most
+// contended, ids of equal score keeping their order.
+func (s *ContentionStats) LeastContended(ids []string) []string {
+	s.mu.Lock()
+	now := s.clock.Now()
+	scores := make([]float64, len(ids))
+	for i, id := range ids {
+		scores[i] = s.score(id, now)
+	}
+	s.mu.Unlock()
+
+	idx := make([]int, len(ids))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return scores[idx[i]] < scores[idx[j]] })
+	ordered := make([]string, len(ids))
+	for i, k := range idx {
+		ordered[i] = ids[k]
+	}
+	return ordered
+}
+
+// WithLeastContended makes Join try the least contended ids first, as
+// ordered by the stats, and record the outcome of every attempt in them,
+// so that steady state claims waste fewer Txns on ids that other
+// processes keep winning. Share one ContentionStats across the claims of
+// a process. It replaces any WithOrdering.
+func WithLeastContended(stats *ContentionStats) Option {
+	return func(o *options) {
+		o.stats = stats
+		o.order = stats.LeastContended
+	}
+}
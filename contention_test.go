@@ -0,0 +1,85 @@
+package stonecutters
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestContentionStats(t *testing.T) {
+	clk := newFakeClock()
+	stats := NewContentionStats(withClock(clk))
+	for _, a := range []Attempt{
+		{ID: "a", Outcome: AttemptConflict},
+		{ID: "a", Outcome: AttemptConflict},
+		{ID: "b", Outcome: AttemptConflict},
+		{ID: "a", Outcome: AttemptClaimed},
+	} {
+		stats.Record(a)
+	}
+	if s := stats.Score("a"); s != 1 {
+		t.Errorf("a claimed after two conflicts should score 1; not: %v", s)
+	}
+	got := stats.LeastContended([]string{"a", "b", "c", "d"})
+	for i, want := range []string{"c", "d", "a", "b"} {
+		if got[i] != want {
+			t.Fatalf("least contended order should be c d a b; not: %v", got)
+		}
+	}
+
+	// a minute without conflicts halves every score, ten all but clear them
+	<-clk.After(time.Minute)
+	if s := stats.Score("b"); s != 0.5 {
+		t.Errorf("b should decay to 0.5 a minute on; not: %v", s)
+	}
+	<-clk.After(9 * time.Minute)
+	stats.Record(Attempt{ID: "c", Outcome: AttemptConflict})
+	got = stats.LeastContended([]string{"a", "b", "c", "d"})
+	for i, want := range []string{"d", "a", "b", "c"} {
+		if got[i] != want {
+			t.Fatalf("long quiet ids should recover ahead of a fresh conflict: %v", got)
+		}
+	}
+	if s := stats.Score("a"); s > 0.001 {
+		t.Errorf("a should have recovered; scores: %v", s)
+	}
+}
+
+func TestJoinLeastContended(t *testing.T) {
+	ids := PrefixedNumerics("/contended/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	// another process keeps winning the first id
+	other, err := Join(client, ctx, lease.ID, "other", ids)
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	stats := NewContentionStats(withClock(newFakeClock()))
+	m, err := Join(client, ctx, lease.ID, "hihi", ids, WithLeastContended(stats))
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if m.Key != ids[1] || stats.Score(other.Key) != 1 {
+		t.Fatalf("Join should conflict on %s then claim %s; claimed %s, score %v",
+			other.Key, ids[1], m.Key, stats.Score(other.Key))
+	}
+	if _, err := client.Delete(ctx, m.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	var tried []string
+	report := WithAttemptReport(func(a Attempt) { tried = append(tried, a.ID) })
+	if m, err = Join(client, ctx, lease.ID, "hihi", ids, report, WithLeastContended(stats)); err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if len(tried) != 1 || m.Key != ids[1] {
+		t.Errorf("the contended id should be tried last; tried: %v", tried)
+	}
+}
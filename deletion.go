@@ -0,0 +1,148 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+var KeyDeletedFailure = errors.New("lock: key was deleted while its lease was alive")
+
+// LostReason says why a Session lost a claim.
+type LostReason int
+
+const (
+	LostNone       LostReason = iota // not lost
+	LostExpired                      // the lease expired or was revoked
+	LostSuperseded                   // the key was claimed by another owner, or at another generation
+	LostDeleted                      // the key was deleted while the lease was alive
+)
+
+func (r LostReason) String() string {
+	switch r {
+	case LostNone:
+		return "none"
+	case LostExpired:
+		return "expired"
+	case LostSuperseded:
+		return "superseded"
+	case LostDeleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// DeletionPolicy says what a Session does when a claim's key is deleted
+// out of band, by an operator or a bug, while its lease is still alive.
+type DeletionPolicy int
+
+const (
+	// DeletionIgnore doesn't watch the key; the renewal loop finds it gone
+	// on its next tick and reports the claim lost as superseded.
+	DeletionIgnore DeletionPolicy = iota
+	// DeletionReport drops the claim as soon as the deletion is seen,
+	// reporting it lost with LostDeleted.
+	DeletionReport
+	// DeletionReclaim claims the key again under the same lease and value,
+	// reporting a failure with LostDeleted which isn't Lost. The claim has
+	// a new generation, see Claim.Generation. Should another owner have
+	// claimed it first, the claim is dropped as with DeletionReport.
+	DeletionReclaim
+)
+
+// WithDeletionWatch makes Session.Claim watch the claimed key and handle
+// its deletion while the lease is still alive as the policy says, rather
+// than leaving it to the next renewal. Without it, the owner runs on
+// believing it holds the id until then, and can end up sharing it with
+// whoever claims it next.
+func WithDeletionWatch(policy DeletionPolicy) Option {
+	return func(o *options) {
+		o.deletion = policy
+	}
+}
+
+// member returns a copy of the claim's Member at its current generation,
+// which may be renewed by a deletion watch.
+func (cl *Claim) member() Member {
+	cl.smu.Lock()
+	defer cl.smu.Unlock()
+	m := cl.Member
+	if cl.generation != 0 {
+		m.CreateRevision = cl.generation
+	}
+	return m
+}
+
+// watchDeleted watches the claim's key until the claim is done, handling
+// deletions while the lease is alive as the policy says. Deletions because
+// the lease is gone are left to the renewal loop.
+func (s *Session) watchDeleted(cl *Claim, policy DeletionPolicy) {
+	defer s.watchers.Done()
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-cl.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	rev := cl.member().CreateRevision + 1
+	for ctx.Err() == nil {
+		wch := s.c.Watch(ctx, cl.Key, clientv3.WithRev(rev), clientv3.WithFilterPut())
+		for wresp := range wch {
+			if wresp.Err() != nil {
+				rev = 0 // compacted; the renewal loop covers the gap
+				break
+			}
+			if len(wresp.Events) == 0 {
+				continue
+			}
+			next, ok := s.keyDeleted(ctx, cl, policy)
+			if !ok {
+				return
+			}
+			rev = next
+			break
+		}
+	}
+}
+
+// keyDeleted handles a deletion of the claim's key, returning the revision
+// to go on watching from, or false if the claim is no longer watched.
+func (s *Session) keyDeleted(ctx context.Context, cl *Claim, policy DeletionPolicy) (int64, bool) {
+	select {
+	case <-cl.done:
+		return 0, false // released or dropped
+	default:
+	}
+	ttl, err := s.c.TimeToLive(ctx, cl.LeaseID)
+	if err != nil || ttl.TTL <= 0 {
+		return 0, false // the lease is gone, the renewal loop reports it
+	}
+
+	f := ClaimFailure{Key: cl.Key, Err: KeyDeletedFailure, Reason: LostDeleted}
+	var next int64
+	if policy == DeletionReclaim {
+		if txn, err := kvPutLease(s.c, ctx, cl.LeaseID, cl.Key, cl.Value); err == nil {
+			cl.smu.Lock()
+			cl.generation = txn.Header.Revision
+			cl.smu.Unlock()
+			next = txn.Header.Revision + 1
+		} else {
+			f.Lost = true
+		}
+	} else {
+		f.Lost = true
+	}
+	if f.Lost {
+		s.drop(cl)
+	}
+	select {
+	case s.failures <- f:
+	default:
+	}
+	return next, !f.Lost
+}
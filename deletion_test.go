@@ -0,0 +1,62 @@
+package stonecutters
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSessionDeletionWatch(t *testing.T) {
+	ids := PrefixedNumerics("/deleted/", 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSession(client)
+	defer s.Close()
+	reported, err := s.Claim(ctx, "hihi", ids, 10, WithDeletionWatch(DeletionReport))
+	if err != nil {
+		t.Fatalf("Claim err: %v", err)
+	}
+	reclaimed, err := s.Claim(ctx, "hihi", ids, 10, WithDeletionWatch(DeletionReclaim))
+	if err != nil {
+		t.Fatalf("Claim err: %v", err)
+	}
+
+	// an operator deletes both keys, well before the next renewal
+	for _, cl := range []*Claim{reported, reclaimed} {
+		if _, err := client.Delete(ctx, cl.Key); err != nil {
+			t.Fatalf("error deleting %s: %v", cl.Key, err)
+		}
+	}
+	seen := make(map[string]ClaimFailure)
+	for len(seen) < 2 {
+		select {
+		case f := <-s.Failures():
+			seen[f.Key] = f
+		case <-time.After(2 * time.Second):
+			t.Fatalf("deletions should be reported promptly; saw: %v", seen)
+		}
+	}
+	if f := seen[reported.Key]; !f.Lost || f.Reason != LostDeleted || f.Err != KeyDeletedFailure {
+		t.Errorf("%s should be lost as deleted: %+v", reported.Key, f)
+	}
+	if f := seen[reclaimed.Key]; f.Lost || f.Reason != LostDeleted {
+		t.Errorf("%s should be reclaimed after its deletion: %+v", reclaimed.Key, f)
+	}
+	if ok, err := s.ConfirmOwnership(ctx, reclaimed.Key); err != nil || !ok {
+		t.Errorf("reclaimed claim should be owned at its new generation; ok[%v] err[%v]", ok, err)
+	}
+	if gen := reclaimed.Generation(); gen <= reclaimed.CreateRevision {
+		t.Errorf("reclaimed generation %d should follow the first claim's %d", gen, reclaimed.CreateRevision)
+	}
+
+	// releasing isn't an external deletion
+	if err := s.Release(ctx, reclaimed.Key); err != nil {
+		t.Fatalf("error releasing %s: %v", reclaimed.Key, err)
+	}
+	select {
+	case f := <-s.Failures():
+		t.Errorf("release should not be reported: %+v", f)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
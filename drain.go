@@ -0,0 +1,42 @@
+package stonecutters
+
+import (
+	"context"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// DrainPool tears down the pool under prefix one member at a time: each
+// member is passed to onMember, eg to signal its process to shut down, then
+// awaited for up to 'timeout' to release its key before moving on to the
+// next. Members read at the start are drained in key order; later joiners
+// are not.
+//
+// undrained lists the members for which onMember returned an error or which
+// still held their key at the deadline. err is set only if the pool could
+// not be read, or the context closed, in which case the members not yet
+// reached are undrained too.
+func DrainPool(c *clientv3.Client, ctx context.Context, prefix string, timeout time.Duration,
+	onMember func(Member) error) (undrained []Member, err error) {
+	r, err := ReadRoster(c, ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range r.Members {
+		if err := checkContext(ctx); err != nil {
+			return append(undrained, r.Members[i:]...), err
+		}
+		if err := onMember(m); err != nil {
+			undrained = append(undrained, m)
+			continue
+		}
+		wctx, cancel := context.WithTimeout(ctx, timeout)
+		err := waitDeleted(c, wctx, m.Key, r.Revision+1)
+		cancel()
+		if err != nil {
+			undrained = append(undrained, m)
+		}
+	}
+	return undrained, checkContext(ctx)
+}
@@ -0,0 +1,46 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDrainPool(t *testing.T) {
+	prefix := "/drain/"
+	ids := PrefixedNumerics(prefix, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for range ids {
+		lease, err := client.Grant(ctx, int64(30))
+		if err != nil {
+			t.Fatalf("error creating lease: %v", err)
+		}
+		defer client.Revoke(ctx, lease.ID)
+		if _, err := Join(client, ctx, lease.ID, "hihi", ids); err != nil {
+			t.Fatalf("Join err: %v", err)
+		}
+	}
+
+	// the first shuts down, the second ignores the signal, the third errors
+	undrained, err := DrainPool(client, ctx, prefix, 500*time.Millisecond, func(m Member) error {
+		switch m.Key {
+		case ids[0]:
+			go client.Revoke(ctx, m.LeaseID)
+		case ids[2]:
+			return errors.New("unreachable")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("DrainPool err: %v", err)
+	}
+	if len(undrained) != 2 || undrained[0].Key != ids[1] || undrained[1].Key != ids[2] {
+		t.Errorf("undrained should be %s and %s; not: %v", ids[1], ids[2], undrained)
+	}
+	if got, err := client.Get(ctx, ids[0]); err != nil || len(got.Kvs) != 0 {
+		t.Errorf("%s should be drained: %v %v", ids[0], got, err)
+	}
+}
@@ -3,97 +3,574 @@ package stonecutters
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+	"go.etcd.io/etcd/mvcc/mvccpb"
 )
 
 var (
-	defaultTimeout      = int64(60)
-	GetIdFailure        = errors.New("lock: failed to get identifier from list")
-	PutSucceededFailure = errors.New("lock: key already registered")
-	VerificationError   = errors.New("lock: k-v values do not match txn request") // very unlikely but strange error
+	defaultTimeout         = int64(60)
+	maxTxnOps              = 128 // etcd's default --max-txn-ops
+	defaultReadParallelism = 8
+	GetIdFailure           = errors.New("lock: failed to get identifier from list")
+	ErrPoolEmpty           = errors.New("lock: no identifiers to claim from")
+	PutSucceededFailure    = errors.New("lock: key already registered")
+	ConditionFailure       = errors.New("lock: claim conditions were not met")
+	VerificationError      = errors.New("lock: k-v values do not match txn request") // very unlikely but strange error
+	SwapFailure            = errors.New("lock: swap target claimed or source no longer held")
+	ReleaseFailure         = errors.New("lock: key not released")
+	ContextDoneFailure     = errors.New("lock: context done before request completed")
+	ErrTTLTooShort         = errors.New("lock: lease granted a shorter TTL than required")
 )
 
 // Member is a struct to encapuslate the etcd data
 // pairing to data Key[Identifier]: Value:[Owner]
 type Member struct {
-	Key   string // Identifier granted
-	Value string // Owner/Hostname
+	Key            string           // Identifier granted
+	Value          string           // Owner/Hostname
+	LeaseID        clientv3.LeaseID // Lease the claim is held under
+	CreateRevision int64            // Revision the claim's key was created at
+
+	// ID is the id Key was derived from under WithKeyTransform, in which
+	// case Key is the etcd key; empty otherwise, Key being the id.
+	ID string
+}
+
+// id returns the id of the claim, with any key transform undone.
+func (m *Member) id() string {
+	if m.ID != "" {
+		return m.ID
+	}
+	return m.Key
+}
+
+// CreateAnomalyError is returned when a claim Txn succeeded but the key it
+// put does not look freshly created, which the Version == 0 compare should
+// make impossible. It points at a misbehaving etcd or a namespace/proxy
+// rewriting keys underneath the client.
+type CreateAnomalyError struct {
+	Key            string
+	Revision       int64 // revision of the claim Txn
+	CreateRevision int64
+	ModRevision    int64
+	Version        int64
+}
+
+func (e *CreateAnomalyError) Error() string {
+	return fmt.Sprintf("lock: claim of %q at revision %d did not create it: create_revision=%d mod_revision=%d version=%d",
+		e.Key, e.Revision, e.CreateRevision, e.ModRevision, e.Version)
+}
+
+// PoolExhaustedError is returned when every id of the pool was tried and
+// none could be claimed, the pool being full rather than misconfigured, as
+// with ErrPoolEmpty. It is GetIdFailure to errors.Is, and counts how the
+// ids were ruled out.
+type PoolExhaustedError struct {
+	Size      int // ids in the pool
+	Conflicts int // already held
+	Filtered  int // rejected by WithFilter
+	Failed    int // skipped on an error, see WithErrorPolicy
+}
+
+func (e *PoolExhaustedError) Error() string {
+	return fmt.Sprintf("%v: all %d taken (%d held, %d filtered, %d failed)",
+		GetIdFailure, e.Size, e.Conflicts, e.Filtered, e.Failed)
+}
+
+func (e *PoolExhaustedError) Is(target error) bool {
+	return target == GetIdFailure
+}
+
+// MultiError collects every error from a call working through many ids.
+type MultiError []error
+
+func (me MultiError) Error() string {
+	if len(me) == 1 {
+		return me[0].Error()
+	}
+	s := fmt.Sprintf("%d errors:", len(me))
+	for _, err := range me {
+		s += " " + err.Error() + ";"
+	}
+	return s
+}
+
+func (me MultiError) Unwrap() []error {
+	return me
 }
 
 // Join iterates over the passed 'ids' and attempts to claim one in
 // etcd with a Lease which is persisted until the context is closed.
-// If the list of ids are all claimed, returns GetIdFailure error with the
-// expectation the caller will handle managing the id list retrys.
+// If the list of ids are all claimed, returns a PoolExhaustedError, which
+// is GetIdFailure to errors.Is, with the expectation the caller will handle
+// managing the id list retrys. An empty list returns ErrPoolEmpty.
+//
+// Honours WithFilter, WithAffinity, WithAdoptValues, WithIdempotencyToken,
+// WithIdempotencyKey, WithMinTTL, WithErrorPolicy, WithConflictPolicy,
+// WithAttemptReport, WithMetrics, WithConditions, WithOrdering,
+// WithLeastContended, WithKeyTransform, WithPinnedVerify and WithAuditSink.
 func Join(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
-	name string, ids []string) (*Member, error) {
-	for _, id := range ids {
-		txn, err := kvPutLease(c, ctx, leaseID, id, name)
+	name string, ids []string, opts ...Option) (*Member, error) {
+	o := newOptions(opts)
+	start := o.clock.Now()
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, ErrPoolEmpty
+	}
+	if o.order != nil {
+		ids = o.order(ids)
+	}
+	if o.keyFn != nil {
+		ids = o.transformKeys(ids)
+	}
+	if o.minTTLFraction > 0 {
+		ttl, err := c.TimeToLive(ctx, leaseID)
 		if err != nil {
+			return nil, authError(err)
+		}
+		if err := checkTTL(ttl.GrantedTTL, o.minTTLRequested, o.minTTLFraction); err != nil {
+			return nil, err
+		}
+	}
+	conds := o.conditions
+	if o.idemKey != "" {
+		if m, err := idempotentClaim(c, ctx, o); m != nil || err != nil {
+			return m, err
+		}
+		conds = append(conds[:len(conds):len(conds)], o.idempotencyCmp())
+	}
+	if o.affinity != "" || o.token != "" || len(o.adoptValues) > 0 {
+		if m, err := adopt(c, ctx, leaseID, name, ids, conds, o); m != nil || err != nil {
+			if m != nil {
+				o.attempt(m.Key, AttemptClaimed, nil)
+				o.observeClaim(start)
+				m.ID = o.idOf[m.Key]
+				o.audited(AuditClaimed, m)
+			}
+			return m, err
+		}
+	}
+	var firstErr error
+	var retried string // the id retried after a conflict, retried only once
+	exhausted := &PoolExhaustedError{Size: len(ids)}
+	for i := 0; i < len(ids); i++ {
+		id := ids[i]
+		if o.filter != nil && !o.filter(id) {
+			o.attempt(id, AttemptFiltered, nil)
+			exhausted.Filtered++
+			continue
+		}
+		if o.limiter != nil {
+			if err := o.limiter.wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		txn, err := kvPutLeaseOps(c, ctx, leaseID, id, name, conds, o.idempotencyOps(leaseID, id)...)
+		if err == PutSucceededFailure {
+			o.attempt(id, AttemptConflict, nil)
+			exhausted.Conflicts++
+			continue
+		} else if err == ConditionFailure {
+			o.attempt(id, AttemptError, err)
+			if o.idemKey != "" {
+				// beaten to it by a retry of our own
+				if m, ierr := idempotentClaim(c, ctx, o); m != nil || ierr != nil {
+					return m, ierr
+				}
+			}
+			return nil, err
+		} else if err != nil {
+			o.attempt(id, AttemptError, err)
+			if cerr := checkContext(ctx); cerr != nil {
+				return nil, cerr
+			}
+			if aerr := authError(err); aerr != err {
+				return nil, aerr
+			}
+			var anomaly *CreateAnomalyError
+			if errors.As(err, &anomaly) || o.errPolicy == ErrorsFailFast {
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			exhausted.Failed++
 			// skip to next id
 			continue
 		} else if txn.Succeeded {
-			v := verifyKvPair(c, id, name)
-			if v {
-				return &Member{Key: id, Value: name}, nil
-			} else {
-				return nil, VerificationError
+			if err := o.verifyClaim(c, id, name, txn.Header.Revision); err != nil {
+				o.attempt(id, AttemptError, err)
+				if err != VerificationError || o.conflict == ConflictSurface {
+					return nil, err
+				}
+				retry, err := resolveConflict(c, ctx, leaseID, name, id, txn.Header.Revision, o)
+				if err != nil {
+					return nil, err
+				}
+				if retry && retried != id {
+					retried = id
+					i--
+				} else {
+					exhausted.Conflicts++
+				}
+				continue
 			}
+			o.attempt(id, AttemptClaimed, nil)
+			o.observeClaim(start)
+			m := &Member{Key: id, Value: name, LeaseID: leaseID, CreateRevision: txn.Header.Revision,
+				ID: o.idOf[id]}
+			o.audited(AuditClaimed, m)
+			return m, nil
+		}
+	}
+	if firstErr != nil && o.errPolicy == ErrorsReport {
+		return nil, firstErr
+	}
+	return nil, exhausted
+}
+
+// resolveConflict undoes a claim of id which read back with another value,
+// as the conflict policy of the options says, returning true if the id
+// should be claimed again. Only the key created at rev, by our claim, is
+// undone; another claim under a shared lease is left alone.
+func resolveConflict(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	name, id string, rev int64, o *options) (bool, error) {
+	ours := []clientv3.Cmp{
+		clientv3.Compare(clientv3.LeaseValue(id), "=", leaseID),
+		clientv3.Compare(clientv3.CreateRevision(id), "=", rev),
+	}
+	if o.conflict == ConflictRetrySameID {
+		_, err := c.Txn(ctx).If(ours...).Then(clientv3.OpDelete(id)).Commit()
+		return true, authError(err)
+	}
+	_, err := c.Txn(ctx).
+		If(append(ours, clientv3.Compare(clientv3.Value(id), "=", name))...).
+		Then(clientv3.OpDelete(id)).
+		Commit()
+	if err != nil {
+		return false, authError(err)
+	}
+	if o.conflict == ConflictBackoffNextID {
+		delay := DecorrelatedJitterBackoff{Base: defaultRetryBase, Max: defaultRetryMax}.Next(1, 0)
+		select {
+		case <-ctx.Done():
+			return false, checkContext(ctx)
+		case <-o.clock.After(delay):
+		}
+	}
+	return false, nil
+}
+
+// adopt takes over the first claimed id the options mark as ours, rebinding
+// it to the lease and name: either its owner Identity has the affinity as
+// its Stable identity or its value is one of the adoptable values. The
+// conditions and any idempotency record are those of a claim. Returns a
+// nil Member if there is none to take over.
+func adopt(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	name string, ids []string, conds []clientv3.Cmp, o *options) (*Member, error) {
+	kvs, err := getKeys(c, ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, kv := range kvs {
+		if kv == nil || (o.filter != nil && !o.filter(ids[i])) {
+			continue
+		}
+		if !o.adoptable(string(kv.Value)) {
+			continue
+		}
+		// only if it's unchanged since we read it
+		resp, err := c.Txn(ctx).
+			If(append([]clientv3.Cmp{clientv3.Compare(clientv3.ModRevision(ids[i]), "=", kv.ModRevision)},
+				conds...)...).
+			Then(append([]clientv3.Op{clientv3.OpPut(ids[i], name, clientv3.WithLease(leaseID))},
+				o.idempotencyOps(leaseID, ids[i])...)...).
+			Commit()
+		if err != nil || !resp.Succeeded {
+			continue
+		}
+		if err := o.verifyClaim(c, ids[i], name, resp.Header.Revision); err != nil {
+			return nil, err
 		}
+		return &Member{Key: ids[i], Value: name, LeaseID: leaseID, CreateRevision: kv.CreateRevision}, nil
 	}
-	return nil, GetIdFailure
+	return nil, nil
+}
+
+// Swap atomically moves the claim on fromKey to toKey within one Txn: toKey
+// is claimed under the lease and fromKey deleted only if toKey is unclaimed
+// and fromKey is still held by name under the lease. Observers never see
+// both or neither held. If either condition fails nothing changes, fromKey
+// is retained and SwapFailure is returned.
+func Swap(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	name, fromKey, toKey string) (*Member, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := c.Txn(ctx).
+		If(clientv3.Compare(clientv3.Version(toKey), "=", 0),
+			clientv3.Compare(clientv3.Value(fromKey), "=", name),
+			clientv3.Compare(clientv3.LeaseValue(fromKey), "=", leaseID)).
+		Then(clientv3.OpPut(toKey, name, clientv3.WithLease(leaseID)),
+			clientv3.OpDelete(fromKey)).
+		Commit()
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Succeeded {
+		return nil, SwapFailure
+	}
+	return &Member{Key: toKey, Value: name, LeaseID: leaseID, CreateRevision: resp.Header.Revision}, nil
 }
 
 // Members returns a list of all Identifiers assigned to an owner.
 func Members(c *clientv3.Client, ids []string) ([]*Member, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	members := make([]*Member, 0)
+	return MembersContext(c, ctx, ids)
+}
 
-	for _, id := range ids {
-		got, err := c.Get(ctx, id)
-		if err == nil {
+// MembersContext is Members bounded by the context rather than a fixed
+// 5 second timeout. The keys are read in parallel, up to 8 at a time unless
+// WithReadParallelism says otherwise, so one slow key doesn't hold up the
+// rest. A key which fails to read, or takes longer than WithKeyTimeout,
+// doesn't blank the result: the members read are returned, in the order of
+// ids, along with a MultiError of the keys which failed.
+//
+// Honours WithSerializable, WithKeyTransform, WithKeyTimeout and
+// WithReadParallelism.
+func MembersContext(c *clientv3.Client, ctx context.Context, ids []string, opts ...Option) ([]*Member, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	readOpts := o.readOpts()
+	parallel := o.readParallel
+	if parallel <= 0 {
+		parallel = defaultReadParallelism
+	}
+
+	found := make([]*Member, len(ids))
+	errs := make([]error, len(ids))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		key, logical := id, ""
+		if o.keyFn != nil {
+			key, logical = o.keyFn(id), id
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, key, logical string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			kctx := ctx
+			if o.keyTimeout > 0 {
+				var cancel context.CancelFunc
+				kctx, cancel = context.WithTimeout(ctx, o.keyTimeout)
+				defer cancel()
+			}
+			got, err := c.Get(kctx, key, readOpts...)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", key, authError(err))
+				return
+			}
 			if len(got.Kvs) > 0 {
-				m := &Member{Key: id, Value: string(got.Kvs[0].Value)}
-				members = append(members, m)
+				kv := got.Kvs[0]
+				found[i] = &Member{Key: key, Value: string(kv.Value), LeaseID: clientv3.LeaseID(kv.Lease),
+					CreateRevision: kv.CreateRevision, ID: logical}
 			}
-		} else {
-			return nil, err
-		}
+		}(i, key, logical)
+	}
+	wg.Wait()
 
+	members := make([]*Member, 0)
+	var failed MultiError
+	for i := range ids {
+		if found[i] != nil {
+			members = append(members, found[i])
+		}
+		if errs[i] != nil {
+			failed = append(failed, errs[i])
+		}
+	}
+	if len(failed) > 0 {
+		return members, failed
 	}
 	return members, nil
 }
 
+// WithKeyTimeout bounds each key read by MembersContext, so a slow key is
+// reported as failed rather than stalling the call until its context ends.
+func WithKeyTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.keyTimeout = d
+	}
+}
+
+// WithReadParallelism sets how many keys MembersContext reads at once.
+func WithReadParallelism(n int) Option {
+	return func(o *options) {
+		o.readParallel = n
+	}
+}
+
+// checkTTL returns ErrTTLTooShort if the granted TTL is less than the
+// fraction of the requested TTL.
+func checkTTL(granted, requested int64, fraction float64) error {
+	if float64(granted) < float64(requested)*fraction {
+		return fmt.Errorf("%w: granted %ds of the %ds requested, below the %.0f%% minimum",
+			ErrTTLTooShort, granted, requested, fraction*100)
+	}
+	return nil
+}
+
+// checkContext returns ContextDoneFailure, wrapping the context's error, if
+// the context is already closed so calls fail fast and unambiguously
+// rather than partway through their etcd requests.
+func checkContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ContextDoneFailure, err)
+	}
+	return nil
+}
+
 // kvPutLease writes a key-val pair with a lease given that the key is not already in use.
 // If the key exists the Txn fails, if it does not exist they key-val is Put.
-func kvPutLease(kvc clientv3.KV, ctx context.Context, leaseID clientv3.LeaseID, key, val string) (*clientv3.TxnResponse, error) {
+// A successful Put is read back in the same Txn to assert it created the key,
+// returning a CreateAnomalyError otherwise. Any further conditions must hold
+// in the same Txn; if the key is free but they don't, ConditionFailure is
+// returned.
+func kvPutLease(kvc clientv3.KV, ctx context.Context, leaseID clientv3.LeaseID, key, val string,
+	conds ...clientv3.Cmp) (*clientv3.TxnResponse, error) {
+	return kvPutLeaseOps(kvc, ctx, leaseID, key, val, conds)
+}
+
+// kvPutLeaseOps is kvPutLease also applying ops, after the claim, should
+// it succeed.
+func kvPutLeaseOps(kvc clientv3.KV, ctx context.Context, leaseID clientv3.LeaseID, key, val string,
+	conds []clientv3.Cmp, ops ...clientv3.Op) (*clientv3.TxnResponse, error) {
 	resp, err := kvc.Txn(ctx).
-		If(clientv3.Compare(clientv3.Version(key), "=", 0)).
-		Then(clientv3.OpPut(key, val, clientv3.WithLease(leaseID))).
+		If(append([]clientv3.Cmp{clientv3.Compare(clientv3.Version(key), "=", 0)}, conds...)...).
+		Then(append([]clientv3.Op{clientv3.OpPut(key, val, clientv3.WithLease(leaseID), clientv3.WithPrevKV()),
+			clientv3.OpGet(key)}, ops...)...).
+		Else(clientv3.OpGet(key, clientv3.WithCountOnly())).
 		Commit()
 	if err != nil {
 		return nil, err
 	}
 	if resp.Succeeded == false {
+		if len(conds) > 0 && resp.Responses[0].GetResponseRange().GetCount() == 0 {
+			return nil, ConditionFailure
+		}
 		return nil, PutSucceededFailure
 	}
+	if err := assertCreated(key, resp); err != nil {
+		return nil, err
+	}
 	return resp, nil
 }
 
+// assertCreated checks the Put in a claim Txn created its key: there was no
+// previous value and the key read back was created, at version 1, by this Txn.
+func assertCreated(key string, resp *clientv3.TxnResponse) error {
+	rev := resp.Header.Revision
+	anomaly := &CreateAnomalyError{Key: key, Revision: rev}
+	if len(resp.Responses) < 2 {
+		return anomaly
+	}
+	if prev := resp.Responses[0].GetResponsePut().GetPrevKv(); prev != nil {
+		anomaly.CreateRevision, anomaly.ModRevision, anomaly.Version = prev.CreateRevision, prev.ModRevision, prev.Version
+		return anomaly
+	}
+	kvs := resp.Responses[1].GetResponseRange().GetKvs()
+	if len(kvs) == 0 {
+		return anomaly
+	}
+	kv := kvs[0]
+	anomaly.CreateRevision, anomaly.ModRevision, anomaly.Version = kv.CreateRevision, kv.ModRevision, kv.Version
+	if kv.CreateRevision != rev || kv.ModRevision != rev || kv.Version != 1 {
+		return anomaly
+	}
+	return nil
+}
+
+// getKeys reads every key in as few Txns as etcd allows, returning the
+// KeyValue for each key in order or nil for keys which don't exist.
+func getKeys(kvc clientv3.KV, ctx context.Context, keys []string) ([]*mvccpb.KeyValue, error) {
+	kvs := make([]*mvccpb.KeyValue, 0, len(keys))
+	for start := 0; start < len(keys); start += maxTxnOps {
+		end := start + maxTxnOps
+		if end > len(keys) {
+			end = len(keys)
+		}
+		ops := make([]clientv3.Op, 0, end-start)
+		for _, k := range keys[start:end] {
+			ops = append(ops, clientv3.OpGet(k))
+		}
+		resp, err := kvc.Txn(ctx).Then(ops...).Commit()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range resp.Responses {
+			var kv *mvccpb.KeyValue
+			if got := r.GetResponseRange().Kvs; len(got) > 0 {
+				kv = got[0]
+			}
+			kvs = append(kvs, kv)
+		}
+	}
+	return kvs, nil
+}
+
 // verifyKvPair returns true if expected key-value strings match their expected values
 func verifyKvPair(client *clientv3.Client, ek, ev string) bool {
+	return verifyKvPairAt(client, ek, ev, 0) == nil
+}
+
+// verifyKvPairAt reads the key at rev, or the latest revision if zero, and
+// returns VerificationError only if its value doesn't match. Failing reads
+// return their own error rather than being mistaken for a mismatch. If rev
+// has been compacted away the read is retried at the latest revision; a
+// value still held now was held then.
+func verifyKvPairAt(client *clientv3.Client, ek, ev string, rev int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	got, err := client.Get(ctx, ek)
+	got, err := client.Get(ctx, ek, clientv3.WithRev(rev))
+	if rpctypes.Error(err) == rpctypes.ErrCompacted {
+		got, err = client.Get(ctx, ek)
+	}
 	if err != nil {
-		return false
+		return authError(err)
 	}
-	if len(got.Kvs) > 0 {
-		if string(got.Kvs[0].Value) == ev {
-			return true
-		}
+	if len(got.Kvs) == 0 || string(got.Kvs[0].Value) != ev {
+		return VerificationError
+	}
+	return nil
+}
+
+// verifyWriteAt is verifyKvPairAt, also requiring the key was last written
+// at rev: the value read is the write of rev itself rather than one before
+// it. Read at the latest revision after a compaction, the key must not have
+// been written since.
+func verifyWriteAt(client *clientv3.Client, ek, ev string, rev int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := client.Get(ctx, ek, clientv3.WithRev(rev))
+	if rpctypes.Error(err) == rpctypes.ErrCompacted {
+		got, err = client.Get(ctx, ek)
+	}
+	if err != nil {
+		return authError(err)
+	}
+	if len(got.Kvs) == 0 || string(got.Kvs[0].Value) != ev || got.Kvs[0].ModRevision != rev {
+		return VerificationError
 	}
-	return false
+	return nil
 }
@@ -2,14 +2,18 @@ package stonecutters
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"go.etcd.io/etcd/clientv3"
 	"go.etcd.io/etcd/embed"
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+	"go.etcd.io/etcd/mvcc/mvccpb"
 )
 
 var (
@@ -66,6 +70,16 @@ func acquireLeaseID(lease clientv3.Lease, ctx context.Context, timeout int64) (c
 	return res.ID, nil
 }
 
+// expireLease ends the lease at once, as its TTL running out while the holder
+// was paused would, so tests of lease loss needn't wait out real seconds.
+// etcd drops the keys bound to it just as it does on expiry.
+func expireLease(t *testing.T, leaseID clientv3.LeaseID) {
+	t.Helper()
+	if _, err := client.Revoke(context.Background(), leaseID); err != nil {
+		t.Fatalf("error expiring lease %x: %v", int64(leaseID), err)
+	}
+}
+
 func TestEtcd(t *testing.T) {
 	t.Run("etcd tests", func(t *testing.T) {
 		t.Run("deleteKeys", deleteKey)
@@ -336,7 +350,7 @@ func TestJoinFailure2(t *testing.T) {
 		if mem != nil {
 			t.Errorf("Member[%v] should not be granted an id!", *mem)
 		}
-		if err != GetIdFailure {
+		if !errors.Is(err, GetIdFailure) {
 			t.Errorf("err[%v] should be GetIdFailure", err)
 		}
 	}
@@ -374,3 +388,621 @@ func TestSetFailuresAndList(t *testing.T) {
 	}
 	t.Logf("%#v", members)
 }
+
+func TestLeaseExpiryFreesID(t *testing.T) {
+	cases := []struct {
+		name string
+		held []string // claimed for the whole test by a live lease
+		ids  []string
+		want string
+	}{
+		{"only id", nil, []string{"lapsed"}, "lapsed"},
+		{"last id", []string{"kept-a", "kept-b"}, []string{"kept-a", "kept-b", "lapsed-c"}, "lapsed-c"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			keeper, err := client.Grant(ctx, int64(30))
+			if err != nil {
+				t.Fatalf("error creating lease: %v", err)
+			}
+			defer client.Revoke(ctx, keeper.ID)
+			for _, id := range tc.held {
+				if _, err := kvPutLease(client, ctx, keeper.ID, id, "hihi-keeper"); err != nil {
+					t.Fatalf("error holding %s: %v", id, err)
+				}
+			}
+
+			// The first holder never keeps its lease alive, it dies once the TTL runs out.
+			first, err := client.Grant(ctx, int64(2))
+			if err != nil {
+				t.Fatalf("error creating lease: %v", err)
+			}
+			mem, err := Join(client, ctx, first.ID, "hihi-first", tc.ids)
+			if err != nil {
+				t.Fatalf("Join err: %v", err)
+			}
+			if mem.Key != tc.want {
+				t.Fatalf("first holder should be assigned %s; not: %s", tc.want, mem.Key)
+			}
+
+			second, err := client.Grant(ctx, int64(30))
+			if err != nil {
+				t.Fatalf("error creating lease: %v", err)
+			}
+			defer client.Revoke(ctx, second.ID)
+
+			// While the first lease is alive the second claimant gets nothing
+			mem, err = Join(client, ctx, second.ID, "hihi-second", tc.ids)
+			if !errors.Is(err, GetIdFailure) {
+				t.Errorf("err[%v] should be GetIdFailure while the first lease is alive", err)
+			}
+			if mem != nil {
+				t.Errorf("Member[%v] should not be granted an id!", *mem)
+			}
+
+			deadline := time.After(10 * time.Second)
+			for {
+				got, err := client.Get(ctx, tc.want)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(got.Kvs) == 0 {
+					break
+				}
+				select {
+				case <-deadline:
+					t.Fatalf("%s was never freed by the expired lease", tc.want)
+				case <-time.After(250 * time.Millisecond):
+				}
+			}
+
+			mem, err = Join(client, ctx, second.ID, "hihi-second", tc.ids)
+			if err != nil {
+				t.Fatalf("Join err after expiry: %v", err)
+			}
+			if mem.Key != tc.want {
+				t.Errorf("second claimant should be assigned the freed %s; not: %s", tc.want, mem.Key)
+			}
+		})
+	}
+}
+
+func TestJoinFilter(t *testing.T) {
+	ids := []string{"drained", "serving", "maint"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(5))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	serving := func(id string) bool { return id == "serving" }
+	mem, err := Join(client, ctx, lease.ID, "hihi", ids, WithFilter(serving))
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if mem.Key != "serving" {
+		t.Errorf("filtered Join should be assigned serving; not: %s", mem.Key)
+	}
+
+	// the only id the filter accepts is now claimed
+	mem, err = Join(client, ctx, lease.ID, "hihi", ids, WithFilter(serving))
+	if !errors.Is(err, GetIdFailure) {
+		t.Errorf("err[%v] should be GetIdFailure", err)
+	}
+	if mem != nil {
+		t.Errorf("Member[%v] should not be granted an id!", *mem)
+	}
+}
+
+func TestSwap(t *testing.T) {
+	ids := []string{"swap-x", "swap-y", "swap-z"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	other, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, other.ID)
+
+	if _, err := kvPutLease(client, ctx, lease.ID, "swap-x", "hihi"); err != nil {
+		t.Fatalf("error claiming swap-x: %v", err)
+	}
+	if _, err := kvPutLease(client, ctx, other.ID, "swap-z", "hoho"); err != nil {
+		t.Fatalf("error claiming swap-z: %v", err)
+	}
+
+	// swap-z is taken; swap-x must be retained
+	mem, err := Swap(client, ctx, lease.ID, "hihi", "swap-x", "swap-z")
+	if err != SwapFailure || mem != nil {
+		t.Errorf("swap onto a claimed id should fail with SwapFailure: %v %v", mem, err)
+	}
+	if !verifyKvPair(client, "swap-x", "hihi") {
+		t.Errorf("swap-x should be retained after a failed swap")
+	}
+
+	mem, err = Swap(client, ctx, lease.ID, "hihi", "swap-x", "swap-y")
+	if err != nil {
+		t.Fatalf("Swap err: %v", err)
+	}
+	if mem.Key != "swap-y" {
+		t.Errorf("swapped member should be swap-y; not: %s", mem.Key)
+	}
+	members, err := Members(client, ids)
+	if err != nil {
+		t.Fatalf("error listing members: %v", err)
+	}
+	if len(members) != 2 || members[0].Key != "swap-y" {
+		t.Errorf("swap-y and swap-z should be the only members: %v", members)
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	ids := []string{"cancelled"}
+	ctx, cancel := context.WithCancel(context.Background())
+	lease, err := client.Grant(ctx, int64(5))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(context.Background(), lease.ID)
+	cancel()
+
+	if _, err := Join(client, ctx, lease.ID, "hihi", ids); !errors.Is(err, ContextDoneFailure) {
+		t.Errorf("Join err[%v] should be ContextDoneFailure", err)
+	}
+	if _, err := MembersContext(client, ctx, ids); !errors.Is(err, ContextDoneFailure) {
+		t.Errorf("MembersContext err[%v] should be ContextDoneFailure", err)
+	}
+	if err := Release(client, ctx, ids[0]); !errors.Is(err, ContextDoneFailure) {
+		t.Errorf("Release err[%v] should be ContextDoneFailure", err)
+	}
+	if _, err := ReleaseIf(client, ctx, lease.ID, ids[0], "hihi"); !errors.Is(err, ContextDoneFailure) {
+		t.Errorf("ReleaseIf err[%v] should be ContextDoneFailure", err)
+	}
+	if err := Revoke(client, ctx, lease.ID); !errors.Is(err, ContextDoneFailure) {
+		t.Errorf("Revoke err[%v] should be ContextDoneFailure", err)
+	}
+}
+
+func TestAssertCreated(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lease, err := client.Grant(ctx, int64(5))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	resp, err := kvPutLease(client, ctx, lease.ID, "created", "hihi")
+	if err != nil {
+		t.Fatalf("error executing txn: %v", err)
+	}
+	if err := assertCreated("created", resp); err != nil {
+		t.Errorf("fresh claim should pass the creation check: %v", err)
+	}
+
+	// a response which overwrote an existing key is an anomaly
+	resp.Responses[0].GetResponsePut().PrevKv = &mvccpb.KeyValue{Key: []byte("created"), CreateRevision: 2, ModRevision: 3, Version: 2}
+	err = assertCreated("created", resp)
+	var anomaly *CreateAnomalyError
+	if !errors.As(err, &anomaly) {
+		t.Fatalf("err[%v] should be a CreateAnomalyError", err)
+	}
+	if anomaly.CreateRevision != 2 || anomaly.ModRevision != 3 || anomaly.Version != 2 {
+		t.Errorf("anomaly should carry the observed revisions: %#v", anomaly)
+	}
+}
+
+func TestJoinMinTTL(t *testing.T) {
+	ids := []string{"min-ttl"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(5))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	// asked for 60s but only got 5s
+	mem, err := Join(client, ctx, lease.ID, "hihi", ids, WithMinTTL(60, 0.5))
+	if !errors.Is(err, ErrTTLTooShort) || mem != nil {
+		t.Errorf("Join err[%v] should be ErrTTLTooShort", err)
+	}
+	mem, err = Join(client, ctx, lease.ID, "hihi", ids, WithMinTTL(5, 0.5))
+	if err != nil || mem == nil {
+		t.Errorf("Join err: %v", err)
+	}
+	if err := checkTTL(3, 10, 0.5); !errors.Is(err, ErrTTLTooShort) {
+		t.Errorf("3s of 10s should be too short at 50%%: %v", err)
+	}
+}
+
+func TestJoinAttemptReport(t *testing.T) {
+	ids := PrefixedNumerics("/report/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(5))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	if _, err := Join(client, ctx, lease.ID, "other", ids[:1]); err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+
+	var attempts []Attempt
+	report := WithAttemptReport(func(a Attempt) { attempts = append(attempts, a) })
+	skip := WithFilter(func(id string) bool { return id != ids[1] })
+	mem, err := Join(client, ctx, lease.ID, "hihi", ids, report, skip)
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if mem.Key != ids[2] {
+		t.Errorf("Join should be assigned %s; not: %s", ids[2], mem.Key)
+	}
+
+	want := []Outcome{AttemptConflict, AttemptFiltered, AttemptClaimed}
+	if len(attempts) != len(want) {
+		t.Fatalf("attempts should be %v; not: %v", want, attempts)
+	}
+	for i, a := range attempts {
+		if a.ID != ids[i] || a.Outcome != want[i] {
+			t.Errorf("attempt %d should be %s %s; not: %s %s", i, ids[i], want[i], a.ID, a.Outcome)
+		}
+	}
+}
+
+func TestVerifyCompacted(t *testing.T) {
+	k := "verify-compacted"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	put, err := client.Put(ctx, k, "hihi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Put(ctx, k, "hihi"); err != nil {
+		t.Fatal(err)
+	}
+	rev, err := Revision(client, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Compact(ctx, rev); err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+
+	// the revision read is gone, so the latest is read instead
+	if err := verifyKvPairAt(client, k, "hihi", put.Header.Revision); err != nil {
+		t.Errorf("verify of a compacted revision should retry at the latest: %v", err)
+	}
+	if err := verifyKvPairAt(client, k, "other", put.Header.Revision); err != VerificationError {
+		t.Errorf("err[%v] should be VerificationError", err)
+	}
+}
+
+func TestVerifyWriteAt(t *testing.T) {
+	k := "/verify-write/1"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	put, err := client.Put(ctx, k, "hihi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Delete(ctx, k)
+	if err := verifyWriteAt(client, k, "hihi", put.Header.Revision); err != nil {
+		t.Errorf("the write at its own revision should verify: %v", err)
+	}
+	if err := verifyWriteAt(client, k, "hihi", put.Header.Revision-1); err != VerificationError {
+		t.Errorf("err[%v] should be VerificationError before the write", err)
+	}
+
+	// a Txn at a later revision which didn't write k, though its value matches
+	other, err := client.Put(ctx, "/verify-write/2", "hihi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Delete(ctx, "/verify-write/2")
+	if err := verifyKvPairAt(client, k, "hihi", other.Header.Revision); err != nil {
+		t.Errorf("the value alone should verify: %v", err)
+	}
+	if err := verifyWriteAt(client, k, "hihi", other.Header.Revision); err != VerificationError {
+		t.Errorf("err[%v] should be VerificationError for a revision which didn't write the key", err)
+	}
+
+	ids := PrefixedNumerics("/verify-join/", 1)
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	if _, err := Join(client, ctx, lease.ID, "hihi", ids, WithPinnedVerify()); err != nil {
+		t.Errorf("Join err: %v", err)
+	}
+}
+
+func TestJoinErrorPolicy(t *testing.T) {
+	ids := PrefixedNumerics("/errpolicy/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// every claim under a revoked lease fails with a real error
+	lease, err := client.Grant(ctx, int64(5))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	expireLease(t, lease.ID)
+
+	if _, err := Join(client, ctx, lease.ID, "hihi", ids); !errors.Is(err, GetIdFailure) {
+		t.Errorf("err[%v] should be GetIdFailure by default", err)
+	}
+
+	var tried int
+	count := WithAttemptReport(func(Attempt) { tried++ })
+	if _, err := Join(client, ctx, lease.ID, "hihi", ids, count, WithErrorPolicy(ErrorsReport)); !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		t.Errorf("err[%v] should be the lease error", err)
+	}
+	if tried != len(ids) {
+		t.Errorf("ErrorsReport should try every id; tried: %d", tried)
+	}
+
+	tried = 0
+	if _, err := Join(client, ctx, lease.ID, "hihi", ids, count, WithErrorPolicy(ErrorsFailFast)); !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		t.Errorf("err[%v] should be the lease error", err)
+	}
+	if tried != 1 {
+		t.Errorf("ErrorsFailFast should stop at the first error; tried: %d", tried)
+	}
+}
+
+func TestResolveConflict(t *testing.T) {
+	k := "/conflict/1"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	// read back with another value under our lease
+	put, err := client.Put(ctx, k, "hihi-other", clientv3.WithLease(lease.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev := put.Header.Revision
+	retry, err := resolveConflict(client, ctx, lease.ID, "hihi", k, rev, newOptions(nil))
+	if err != nil || retry {
+		t.Fatalf("ConflictNextID should move on: %v %v", retry, err)
+	}
+	if got, err := client.Get(ctx, k); err != nil || len(got.Kvs) != 1 {
+		t.Errorf("ConflictNextID should leave a value not ours: %v %v", got, err)
+	}
+
+	// a claim under the same lease created since ours isn't ours to undo
+	retry, err = resolveConflict(client, ctx, lease.ID, "hihi", k, rev-1,
+		newOptions([]Option{WithConflictPolicy(ConflictRetrySameID)}))
+	if err != nil || !retry {
+		t.Fatalf("ConflictRetrySameID should retry: %v %v", retry, err)
+	}
+	if got, err := client.Get(ctx, k); err != nil || len(got.Kvs) != 1 {
+		t.Errorf("ConflictRetrySameID should leave a claim sharing the lease: %v %v", got, err)
+	}
+
+	retry, err = resolveConflict(client, ctx, lease.ID, "hihi", k, rev,
+		newOptions([]Option{WithConflictPolicy(ConflictRetrySameID)}))
+	if err != nil || !retry {
+		t.Fatalf("ConflictRetrySameID should retry: %v %v", retry, err)
+	}
+	if got, err := client.Get(ctx, k); err != nil || len(got.Kvs) != 0 {
+		t.Errorf("ConflictRetrySameID should delete the key on our lease: %v %v", got, err)
+	}
+
+	put, err = client.Put(ctx, k, "hihi", clientv3.WithLease(lease.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveConflict(client, ctx, lease.ID, "hihi", k, put.Header.Revision,
+		newOptions([]Option{WithConflictPolicy(ConflictBackoffNextID)})); err != nil {
+		t.Fatalf("resolveConflict err: %v", err)
+	}
+	if got, err := client.Get(ctx, k); err != nil || len(got.Kvs) != 0 {
+		t.Errorf("our own claim should be released: %v %v", got, err)
+	}
+}
+
+func TestJoinConditions(t *testing.T) {
+	ids := PrefixedNumerics("/fenced/", 2)
+	epoch := "/fenced-epoch"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(5))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	if _, err := client.Put(ctx, epoch, "2"); err != nil {
+		t.Fatalf("error writing epoch: %v", err)
+	}
+	defer client.Delete(ctx, epoch)
+
+	stale := WithConditions(clientv3.Compare(clientv3.Value(epoch), "=", "1"))
+	if _, err := Join(client, ctx, lease.ID, "hihi", ids, stale); err != ConditionFailure {
+		t.Errorf("stale epoch err[%v] should be ConditionFailure", err)
+	}
+	members, err := Members(client, ids)
+	if err != nil {
+		t.Fatalf("error listing members: %v", err)
+	}
+	if len(members) != 0 {
+		t.Errorf("nothing should be claimed under a stale epoch: %v", members)
+	}
+
+	current := WithConditions(clientv3.Compare(clientv3.Value(epoch), "=", "2"))
+	mem, err := Join(client, ctx, lease.ID, "hihi", ids, current)
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if mem.Key != ids[0] {
+		t.Errorf("should claim %s; not: %s", ids[0], mem.Key)
+	}
+	// a claimed id is still a conflict, not a failed condition
+	if _, err := Join(client, ctx, lease.ID, "hihi", ids[:1], current); !errors.Is(err, GetIdFailure) {
+		t.Errorf("claimed id err[%v] should be GetIdFailure", err)
+	}
+}
+
+func TestPoolEmptyOrExhausted(t *testing.T) {
+	ids := PrefixedNumerics("/exhausted/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(5))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	// a misconfigured pool
+	_, err = Join(client, ctx, lease.ID, "hihi", nil)
+	if !errors.Is(err, ErrPoolEmpty) || errors.Is(err, GetIdFailure) {
+		t.Errorf("empty pool err[%v] should be ErrPoolEmpty only", err)
+	}
+	s := NewSession(client)
+	defer s.Close()
+	if _, err := s.Claim(ctx, "hihi", []string{}, 5); !errors.Is(err, ErrPoolEmpty) {
+		t.Errorf("empty pool Claim err[%v] should be ErrPoolEmpty", err)
+	}
+
+	// a full one
+	for range ids[1:] {
+		if _, err := Join(client, ctx, lease.ID, "other", ids[1:]); err != nil {
+			t.Fatalf("Join err: %v", err)
+		}
+	}
+	_, err = Join(client, ctx, lease.ID, "hihi", ids, WithFilter(func(id string) bool { return id != ids[0] }))
+	var pe *PoolExhaustedError
+	if !errors.As(err, &pe) || !errors.Is(err, GetIdFailure) || errors.Is(err, ErrPoolEmpty) {
+		t.Fatalf("full pool err[%v] should be a PoolExhaustedError", err)
+	}
+	if pe.Size != 3 || pe.Conflicts != 2 || pe.Filtered != 1 || pe.Failed != 0 {
+		t.Errorf("full pool should count 2 held and 1 filtered: %+v", pe)
+	}
+}
+
+func TestJoinKeyTransform(t *testing.T) {
+	ids := PrefixedNumerics("node-", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// /sharded/shard-<n>/node-<n>
+	shard := func(id string) string { return fmt.Sprintf("/sharded/shard-%d/%s", len(id)%2, id) }
+	unshard := func(key string) (string, bool) {
+		parts := strings.Split(key, "/")
+		if len(parts) != 4 || !strings.HasPrefix(parts[2], "shard-") {
+			return "", false
+		}
+		return parts[3], true
+	}
+	transform := WithKeyTransform(shard, unshard)
+
+	lease, err := client.Grant(ctx, int64(5))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	if _, err := client.Put(ctx, "/sharded/unrelated", "x", clientv3.WithLease(lease.ID)); err != nil {
+		t.Fatal(err)
+	}
+
+	var filtered []string
+	skipFirst := WithFilter(func(id string) bool {
+		filtered = append(filtered, id)
+		return id != ids[0]
+	})
+	m, err := Join(client, ctx, lease.ID, "hihi", ids, transform, skipFirst)
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if m.ID != ids[1] || m.Key != shard(ids[1]) || filtered[0] != ids[0] {
+		t.Fatalf("Join should claim %s as %s; claimed %s as %s, filtered %v",
+			ids[1], shard(ids[1]), m.ID, m.Key, filtered)
+	}
+	if !verifyKvPair(client, shard(ids[1]), "hihi") {
+		t.Errorf("claim should be written under %s", shard(ids[1]))
+	}
+
+	members, err := MembersContext(client, ctx, ids, transform)
+	if err != nil {
+		t.Fatalf("MembersContext err: %v", err)
+	}
+	if len(members) != 1 || members[0].ID != ids[1] || members[0].Key != m.Key {
+		t.Errorf("members should map back to %s: %v", ids[1], members)
+	}
+	r, err := ReadRoster(client, ctx, "/sharded/", transform)
+	if err != nil {
+		t.Fatalf("ReadRoster err: %v", err)
+	}
+	if r.Count != 1 || r.Members[0].ID != ids[1] {
+		t.Errorf("roster should hold only %s: %+v", ids[1], r)
+	}
+
+	if ok, err := ReleaseIf(client, ctx, lease.ID, m.Key, m.Value); err != nil || !ok {
+		t.Errorf("ReleaseIf of the claimed key should succeed; ok[%v] err[%v]", ok, err)
+	}
+}
+
+func TestMembersPartial(t *testing.T) {
+	ids := PrefixedNumerics("/partial/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	for _, id := range ids {
+		if _, err := kvPutLease(client, ctx, lease.ID, id, "hihi"); err != nil {
+			t.Fatalf("error claiming %s: %v", id, err)
+		}
+	}
+
+	// an empty key etcd refuses to read, between the others
+	members, err := MembersContext(client, ctx, []string{ids[0], "", ids[1], ids[2]}, WithReadParallelism(2))
+	var failed MultiError
+	if !errors.As(err, &failed) || len(failed) != 1 {
+		t.Fatalf("the failed key should be reported alone; err: %v", err)
+	}
+	if len(members) != 3 {
+		t.Fatalf("the other keys should still be read: %v", members)
+	}
+	for i, m := range members {
+		if m.Key != ids[i] {
+			t.Errorf("member %d should be %s; not: %s", i, ids[i], m.Key)
+		}
+	}
+
+	// every read outlasting its timeout is reported, none blank the others
+	members, err = MembersContext(client, ctx, ids, WithKeyTimeout(time.Nanosecond))
+	if !errors.As(err, &failed) || len(failed) != len(ids) || len(members) != 0 {
+		t.Errorf("timed out keys should each be reported: %v %v", members, err)
+	}
+}
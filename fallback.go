@@ -0,0 +1,128 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var defaultFallbackAfter = 5 * time.Second
+
+// WithFallbackID lets Acquire carry on with the pre-assigned id when etcd
+// can't be reached within 'after', rather than failing. Defaults to 5s if
+// 'after' is zero.
+//
+// THIS TRADES SAFETY FOR AVAILABILITY. A fallback id is unconfirmed: nothing
+// in etcd marks it as held, so another process, or another node sharing the
+// same fallback, may be using it too. Only use it for best-effort work which
+// tolerates duplicate ids, with a fallback id unique to each node.
+//
+// While unconfirmed the id counts towards Status().Unconfirmed and Acquire
+// keeps trying to claim exactly that id in the background, backing off
+// between attempts; once claimed it counts towards Held instead, until
+// released or lost, as if Acquire had claimed it. Acquire still fails as
+// usual when etcd answers, eg when the pool is exhausted or auth fails.
+func WithFallbackID(id string, after time.Duration) Option {
+	return func(o *options) {
+		o.fallbackID = id
+		o.fallbackAfter = after
+	}
+}
+
+// acquireOrFallback is Acquire, falling back to the fallback id if etcd is
+// unreachable.
+func (l *Locker) acquireOrFallback(ctx context.Context, name string, all []Option,
+	o *options) (string, func(), error) {
+	after := o.fallbackAfter
+	if after <= 0 {
+		after = defaultFallbackAfter
+	}
+	cctx, cancel := context.WithTimeout(ctx, after)
+	id, release, err := l.acquire(ctx, cctx, name, all, o, nil)
+	cancel()
+	if err == nil || ctx.Err() != nil || !unreachable(err) {
+		return id, release, err
+	}
+	return o.fallbackID, l.fallback(ctx, name, all, o), nil
+}
+
+// unreachable reports if err from a claim is a failure to reach etcd,
+// rather than an answer from it.
+func unreachable(err error) bool {
+	var anomaly *CreateAnomalyError
+	return !errors.Is(err, GetIdFailure) && !errors.Is(err, ErrPoolEmpty) &&
+		!errors.Is(err, ErrAuthExpired) && !errors.Is(err, ConditionFailure) &&
+		!errors.Is(err, ErrTTLTooShort) && !errors.As(err, &anomaly)
+}
+
+// fallback counts the fallback id as unconfirmed and claims it in the
+// background until claimed, released, or the context closes, which releases
+// it. Returns its release.
+func (l *Locker) fallback(ctx context.Context, name string, all []Option, o *options) func() {
+	fctx, cancel := context.WithCancel(ctx)
+	l.mu.Lock()
+	l.unconfirmed++
+	l.mu.Unlock()
+
+	var mu sync.Mutex
+	var claimed func() // the release of the claim, once confirmed
+	var released bool  // by the caller, or by the context closing
+	unconfirm := func() {
+		l.mu.Lock()
+		l.unconfirmed--
+		l.mu.Unlock()
+	}
+	// markReleased marks the fallback released, returning the release of its
+	// claim if confirmed, and whether it was unconfirmed until now.
+	markReleased := func() (func(), bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		was := released
+		released = true
+		return claimed, !was && claimed == nil
+	}
+	go func() {
+		backoff := DecorrelatedJitterBackoff{Base: defaultRetryBase, Max: defaultRetryMax}
+		var delay time.Duration
+		for attempt := 1; ; attempt++ {
+			delay = backoff.Next(attempt, delay)
+			select {
+			case <-fctx.Done():
+				// released itself, as a normal Acquire does
+				if _, unconfirmed := markReleased(); unconfirmed {
+					unconfirm()
+				}
+				return
+			case <-o.clock.After(delay):
+			}
+			_, rel, err := l.acquire(fctx, fctx, name, all, o, []string{o.fallbackID})
+			if err != nil {
+				continue
+			}
+			mu.Lock()
+			if released {
+				mu.Unlock()
+				rel()
+				return
+			}
+			claimed = rel
+			mu.Unlock()
+			unconfirm()
+			return
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			rel, unconfirmed := markReleased()
+			if rel != nil {
+				rel()
+			} else if unconfirmed {
+				unconfirm()
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package stonecutters
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestLockerFallbackUnreachable(t *testing.T) {
+	ids := PrefixedNumerics("/fallback-down/", 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// nothing listens there
+	down, err := clientv3.New(clientv3.Config{Endpoints: []string{"localhost:1"}})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer down.Close()
+
+	l := NewLocker(down, ids, WithFallbackID(ids[1], 200*time.Millisecond))
+	id, release, err := l.Acquire(ctx, "hihi")
+	if err != nil {
+		t.Fatalf("Acquire should fall back: %v", err)
+	}
+	if id != ids[1] {
+		t.Errorf("Acquire should fall back to %s; not: %s", ids[1], id)
+	}
+	if st := l.Status(); st.Unconfirmed != 1 || st.Held != 0 {
+		t.Errorf("the fallback should be unconfirmed: %+v", st)
+	}
+	release()
+	release()
+	if st := l.Status(); st.Unconfirmed != 0 {
+		t.Errorf("a released fallback should no longer count: %+v", st)
+	}
+
+	// the context closing releases it, as it would a claim
+	fctx, fcancel := context.WithCancel(ctx)
+	l = NewLocker(down, ids, WithFallbackID(ids[1], 200*time.Millisecond))
+	if _, release, err = l.Acquire(fctx, "hihi"); err != nil {
+		t.Fatalf("Acquire should fall back: %v", err)
+	}
+	fcancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for st := l.Status(); st.Unconfirmed != 0; st = l.Status() {
+		if time.Now().After(deadline) {
+			t.Fatalf("the fallback should be released with its context: %+v", st)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	release()
+	if st := l.Status(); st.Unconfirmed != 0 {
+		t.Errorf("releasing it again should change nothing: %+v", st)
+	}
+
+	// etcd answering is no reason to fall back
+	l = NewLocker(client, nil, WithFallbackID(ids[1], time.Second))
+	if _, _, err := l.Acquire(ctx, "hihi"); err != ErrPoolEmpty {
+		t.Errorf("Acquire should fail as usual: %v", err)
+	}
+}
+
+func TestLockerFallbackConfirmed(t *testing.T) {
+	ids := PrefixedNumerics("/fallback-up/", 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// too soon for etcd to answer the first time
+	l := NewLocker(client, ids, WithFallbackID(ids[1], time.Nanosecond))
+	id, release, err := l.Acquire(ctx, "hihi")
+	if err != nil {
+		t.Fatalf("Acquire should fall back: %v", err)
+	}
+	defer release()
+	if id != ids[1] {
+		t.Errorf("Acquire should fall back to %s; not: %s", ids[1], id)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for st := l.Status(); st.Unconfirmed != 0 || st.Held != 1; st = l.Status() {
+		if time.Now().After(deadline) {
+			t.Fatalf("the fallback should be claimed in the background: %+v", st)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if got, err := client.Get(ctx, ids[1]); err != nil || len(got.Kvs) != 1 {
+		t.Fatalf("%s should be claimed: %v %v", ids[1], got, err)
+	}
+	release()
+	if got, err := client.Get(ctx, ids[1]); err != nil || len(got.Kvs) != 0 {
+		t.Errorf("%s should be released: %v %v", ids[1], got, err)
+	}
+	if st := l.Status(); st.Held != 0 || st.Unconfirmed != 0 {
+		t.Errorf("nothing should be held once released: %+v", st)
+	}
+}
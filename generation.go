@@ -0,0 +1,80 @@
+package stonecutters
+
+import (
+	"context"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// ConfirmOwnership returns true if m is still the claim etcd holds on its
+// key: held under m's lease, with m's value, and at m's generation.
+//
+// The generation of a claim is the CreateRevision of its key, rather than a
+// counter stored in the value, so values remain the member names. Every
+// claim of a key creates it afresh, so a key released or expired and then
+// claimed again has a new generation even if it was by the same name under
+// the same lease. After a partition heals a false return means the claim
+// was superseded and the id must no longer be used. A Member without a
+// CreateRevision is checked by lease and value alone.
+func ConfirmOwnership(c *clientv3.Client, ctx context.Context, m *Member) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+	got, err := c.Get(ctx, m.Key)
+	if err != nil {
+		return false, authError(err)
+	}
+	if len(got.Kvs) == 0 {
+		return false, nil
+	}
+	kv := got.Kvs[0]
+	if string(kv.Value) != m.Value || clientv3.LeaseID(kv.Lease) != m.LeaseID {
+		return false, nil
+	}
+	return m.CreateRevision == 0 || kv.CreateRevision == m.CreateRevision, nil
+}
+
+// AssertOwned returns true if the claim was renewed within its TTL, going
+// by the Session's latest keepalive alone. It never reaches etcd, so is
+// cheap enough to call before every sensitive operation, but it can't tell
+// a claim superseded since the last renewal; see ConfirmOwned.
+func (cl *Claim) AssertOwned() bool {
+	cl.smu.Lock()
+	defer cl.smu.Unlock()
+	return !cl.state.final() && cl.clock.Now().Before(cl.expires)
+}
+
+// ConfirmOwned checks with etcd, as ConfirmOwnership does, that the claim
+// is still held at the generation it was claimed at. Authoritative where
+// AssertOwned is cheap, it holds even when renewals are late; false once
+// the Session has lost or released the claim.
+func (cl *Claim) ConfirmOwned(ctx context.Context) (bool, error) {
+	if cl.State().final() {
+		return false, nil
+	}
+	m := cl.member()
+	return ConfirmOwnership(cl.c, ctx, &m)
+}
+
+// Generation returns the CreateRevision the claim is held at. It starts as
+// that of the embedded Member, which is never changed, and is renewed each
+// time DeletionReclaim claims the key again.
+func (cl *Claim) Generation() int64 {
+	return cl.member().CreateRevision
+}
+
+// renewed pushes out the claim's deadline after a keepalive granted ttl
+// seconds.
+func (cl *Claim) renewed(ttl int64) {
+	cl.smu.Lock()
+	defer cl.smu.Unlock()
+	cl.expires = cl.clock.Now().Add(time.Duration(ttl) * time.Second)
+}
+
+// deadline returns the time the claim lapses unless renewed first.
+func (cl *Claim) deadline() time.Time {
+	cl.smu.Lock()
+	defer cl.smu.Unlock()
+	return cl.expires
+}
@@ -0,0 +1,137 @@
+package stonecutters
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConfirmOwnership(t *testing.T) {
+	ids := PrefixedNumerics("/generation/", 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	m, err := Join(client, ctx, lease.ID, "hihi", ids)
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if ok, err := ConfirmOwnership(client, ctx, m); err != nil || !ok {
+		t.Fatalf("fresh claim should be owned; ok[%v] err[%v]", ok, err)
+	}
+
+	// lost during a partition and claimed again, by the same name and lease
+	if _, err := client.Delete(ctx, m.Key); err != nil {
+		t.Fatalf("error deleting %s: %v", m.Key, err)
+	}
+	again, err := Join(client, ctx, lease.ID, "hihi", ids)
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if ok, err := ConfirmOwnership(client, ctx, m); err != nil || ok {
+		t.Errorf("superseded generation should not be owned; ok[%v] err[%v]", ok, err)
+	}
+	if ok, err := ConfirmOwnership(client, ctx, again); err != nil || !ok {
+		t.Errorf("current generation should be owned; ok[%v] err[%v]", ok, err)
+	}
+}
+
+func TestSessionConfirmOwnership(t *testing.T) {
+	ids := PrefixedNumerics("/sessiongeneration/", 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSession(client)
+	defer s.Close()
+	cl, err := s.Claim(ctx, "hihi", ids, 3)
+	if err != nil {
+		t.Fatalf("Claim err: %v", err)
+	}
+	if ok, err := s.ConfirmOwnership(ctx, cl.Key); err != nil || !ok {
+		t.Fatalf("claim should be owned; ok[%v] err[%v]", ok, err)
+	}
+
+	// recreated under the claim's own lease and value, but a new generation
+	if _, err := client.Delete(ctx, cl.Key); err != nil {
+		t.Fatalf("error deleting %s: %v", cl.Key, err)
+	}
+	if _, err := Join(client, ctx, cl.LeaseID, cl.Value, ids); err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if ok, err := s.ConfirmOwnership(ctx, cl.Key); err != nil || ok {
+		t.Errorf("superseded claim should not be owned; ok[%v] err[%v]", ok, err)
+	}
+	select {
+	case f := <-s.Failures():
+		if f.Key != cl.Key || !f.Lost {
+			t.Errorf("failure should report %s lost; not: %#v", cl.Key, f)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("renewal should drop the superseded claim")
+	}
+}
+
+func TestClaimAssertOwned(t *testing.T) {
+	ids := PrefixedNumerics("/assertowned/", 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	m, err := Join(client, ctx, lease.ID, "hihi", ids)
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	clk := newFakeClock()
+	cl := newClaim(m)
+	cl.c, cl.clock, cl.LeaseID = client, clk, lease.ID
+	cl.setState(ClaimClaimed)
+	cl.renewed(3)
+	if !cl.AssertOwned() {
+		t.Fatal("a claim just renewed should be owned")
+	}
+
+	// no keepalive for longer than the TTL
+	<-clk.After(4 * time.Second)
+	if cl.AssertOwned() {
+		t.Error("a claim not renewed within its TTL should be stale")
+	}
+	if ok, err := cl.ConfirmOwned(ctx); err != nil || !ok {
+		t.Errorf("etcd still holds the claim; ok[%v] err[%v]", ok, err)
+	}
+}
+
+func TestSessionClaimConfirmOwned(t *testing.T) {
+	ids := PrefixedNumerics("/confirmowned/", 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSession(client)
+	defer s.Close()
+	cl, err := s.Claim(ctx, "hihi", ids, 3)
+	if err != nil {
+		t.Fatalf("Claim err: %v", err)
+	}
+	if !cl.AssertOwned() {
+		t.Error("a fresh claim should be owned")
+	}
+	if ok, err := cl.ConfirmOwned(ctx); err != nil || !ok {
+		t.Fatalf("claim should be owned; ok[%v] err[%v]", ok, err)
+	}
+	if err := s.Release(ctx, cl.Key); err != nil {
+		t.Fatalf("Release err: %v", err)
+	}
+	if cl.AssertOwned() {
+		t.Error("a released claim should not be owned")
+	}
+	if ok, err := cl.ConfirmOwned(ctx); err != nil || ok {
+		t.Errorf("a released claim should not be owned; ok[%v] err[%v]", ok, err)
+	}
+}
@@ -0,0 +1,84 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+var poolSizePrefix = "size/"
+
+// PoolSizeKey returns the key holding the current size of a growing pool
+// under prefix.
+func PoolSizeKey(prefix string) string {
+	return poolSizePrefix + prefix
+}
+
+// JoinGrowing claims one of the ids PrefixedNumerics(prefix, size) under
+// the lease, where size is the pool's current size starting at 'initial'.
+// When every id is taken the pool doubles, up to 'max' ids, and the new ids
+// are tried. Growth is a compare-and-swap on the size at PoolSizeKey, so
+// claimants which find the pool full at once grow it only once between
+// them. Returns GetIdFailure once the pool is full at 'max'.
+//
+// Honours the options of Join.
+func JoinGrowing(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	name, prefix string, initial, max int, opts ...Option) (*Member, error) {
+	var m *Member
+	err := growPool(c, ctx, prefix, initial, max, func(size int) error {
+		var err error
+		m, err = Join(c, ctx, leaseID, name, PrefixedNumerics(prefix, size), opts...)
+		return err
+	})
+	return m, err
+}
+
+// growPool calls join with the pool's current size, doubling the size and
+// calling it again while join returns GetIdFailure, up to max. A stored size
+// which doesn't parse or is below one is taken as initial, itself at least
+// one.
+func growPool(c *clientv3.Client, ctx context.Context, prefix string, initial, max int,
+	join func(size int) error) error {
+	if initial < 1 {
+		initial = 1
+	}
+	key := PoolSizeKey(prefix)
+	for {
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+		got, err := c.Get(ctx, key)
+		if err != nil {
+			return authError(err)
+		}
+		size, rev := initial, int64(0)
+		if len(got.Kvs) > 0 {
+			rev = got.Kvs[0].ModRevision
+			if n, err := strconv.Atoi(string(got.Kvs[0].Value)); err == nil && n >= 1 {
+				size = n
+			}
+		}
+		if size > max {
+			size = max
+		}
+
+		err = join(size)
+		if !errors.Is(err, GetIdFailure) || size >= max {
+			return err
+		}
+		grown := size * 2
+		if grown > max {
+			grown = max
+		}
+		// if another claimant grew it first, the next pass finds it grown
+		_, err = c.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).
+			Then(clientv3.OpPut(key, strconv.Itoa(grown))).
+			Commit()
+		if err != nil {
+			return authError(err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestJoinGrowing(t *testing.T) {
+	prefix := "/growing/"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	// a burst of claimants on a pool of 2 growing to 8
+	keys := make(chan string, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m, err := JoinGrowing(client, ctx, lease.ID, "hihi", prefix, 2, 8)
+			if err != nil {
+				t.Errorf("JoinGrowing err: %v", err)
+				return
+			}
+			keys <- m.Key
+		}()
+	}
+	wg.Wait()
+	close(keys)
+	seen := make(map[string]bool)
+	for k := range keys {
+		if seen[k] {
+			t.Errorf("%s claimed twice", k)
+		}
+		seen[k] = true
+	}
+
+	got, err := client.Get(ctx, PoolSizeKey(prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Kvs) != 1 || string(got.Kvs[0].Value) != "8" {
+		t.Errorf("pool should have grown to exactly 8: %v", got.Kvs)
+	}
+	if _, err := JoinGrowing(client, ctx, lease.ID, "hihi", prefix, 2, 8); !errors.Is(err, GetIdFailure) {
+		t.Errorf("err[%v] should be GetIdFailure once full at the max", err)
+	}
+}
+
+func TestLockerAutoGrow(t *testing.T) {
+	prefix := "/autogrow/"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := &PoolConfig{AutoGrow: true, InitialSize: 1, MaxSize: 3}
+	if err := SavePoolConfig(client, ctx, prefix, cfg); err != nil {
+		t.Fatalf("SavePoolConfig err: %v", err)
+	}
+	ids := PrefixedNumerics(prefix, 5)
+	l := NewLocker(client, ids)
+	if _, err := l.LoadConfig(ctx, prefix); err != nil {
+		t.Fatalf("LoadConfig err: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		id, release, err := l.Acquire(ctx, "hihi")
+		if err != nil {
+			t.Fatalf("Acquire err: %v", err)
+		}
+		defer release()
+		if id != ids[i] {
+			t.Errorf("Acquire should be assigned %s; not: %s", ids[i], id)
+		}
+	}
+	if _, _, err := l.Acquire(ctx, "hihi"); !errors.Is(err, GetIdFailure) {
+		t.Errorf("err[%v] should be GetIdFailure past MaxSize", err)
+	}
+}
+
+func TestJoinGrowingCorruptSize(t *testing.T) {
+	prefix := "/growing-corrupt/"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	// a corrupt size is taken as the initial size, and grows from there
+	for _, size := range []string{"0", "-3", "junk"} {
+		if _, err := client.Put(ctx, PoolSizeKey(prefix), size); err != nil {
+			t.Fatal(err)
+		}
+		m, err := JoinGrowing(client, ctx, lease.ID, "hihi", prefix, 1, 8)
+		if err != nil {
+			t.Fatalf("JoinGrowing err with size %q: %v", size, err)
+		}
+		if _, err := client.Delete(ctx, m.Key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// as is an initial size below one
+	if _, err := client.Delete(ctx, PoolSizeKey(prefix)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := JoinGrowing(client, ctx, lease.ID, "hihi", prefix, 0, 8); err != nil {
+		t.Fatalf("JoinGrowing err with initial 0: %v", err)
+	}
+	if _, err := JoinGrowing(client, ctx, lease.ID, "hihi", prefix, 0, 8); err != nil {
+		t.Fatalf("JoinGrowing err growing from initial 0: %v", err)
+	}
+
+	// and a Locker neither panics nor reports the pool empty
+	if _, err := client.Put(ctx, PoolSizeKey(prefix), "-3"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &PoolConfig{AutoGrow: true, InitialSize: 1, MaxSize: 8}
+	if err := SavePoolConfig(client, ctx, prefix, cfg); err != nil {
+		t.Fatalf("SavePoolConfig err: %v", err)
+	}
+	l := NewLocker(client, PrefixedNumerics(prefix, 8))
+	if _, err := l.LoadConfig(ctx, prefix); err != nil {
+		t.Fatalf("LoadConfig err: %v", err)
+	}
+	_, release, err := l.Acquire(ctx, "hihi")
+	if err != nil {
+		t.Fatalf("Acquire err: %v", err)
+	}
+	release()
+}
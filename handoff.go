@@ -0,0 +1,188 @@
+package stonecutters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+var (
+	handoffPrefix   = "handoff/"
+	TransferFailure = errors.New("lock: key is not held by the lease, can't transfer")
+	HandoffFailure  = errors.New("lock: another instance is already waiting on the handoff")
+)
+
+// HandoffKey returns the key an incoming instance registers under while
+// waiting for key to be handed to it.
+func HandoffKey(key string) string {
+	return handoffPrefix + key
+}
+
+// handoffOffer is the value of a handoff key: who to transfer the key to.
+type handoffOffer struct {
+	Name    string `json:"name"`
+	LeaseID int64  `json:"lease"`
+}
+
+// Transfer atomically rebinds key from name under leaseID to toName under
+// toLease, only if it is still held by name under leaseID; otherwise
+// nothing changes and TransferFailure is returned. The key is never free
+// in between, so no other claimant can take it.
+func Transfer(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	name, key string, toLease clientv3.LeaseID, toName string) (*Member, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := c.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", name),
+			clientv3.Compare(clientv3.LeaseValue(key), "=", leaseID)).
+		Then(clientv3.OpPut(key, toName, clientv3.WithLease(toLease)),
+			clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return nil, authError(err)
+	}
+	if !resp.Succeeded {
+		return nil, TransferFailure
+	}
+	m := &Member{Key: key, Value: toName, LeaseID: toLease}
+	if kvs := resp.Responses[1].GetResponseRange().GetKvs(); len(kvs) > 0 {
+		m.CreateRevision = kvs[0].CreateRevision
+	}
+	return m, nil
+}
+
+// AwaitHandoff registers the caller as waiting for key under its handoff
+// key, bound to leaseID so the registration goes away with the lease, and
+// blocks until key is transferred to name under leaseID. Should the holder
+// release key instead, or not exist at all, AwaitHandoff claims it as Join
+// does. Only one instance may wait on a key at a time, others get
+// HandoffFailure. The registration is removed on return; bound the wait
+// with the context.
+func AwaitHandoff(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	name, key string) (*Member, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	hkey := HandoffKey(key)
+	offer, _ := json.Marshal(handoffOffer{Name: name, LeaseID: int64(leaseID)})
+	resp, err := c.Txn(ctx).
+		If(clientv3.Compare(clientv3.Version(hkey), "=", 0)).
+		Then(clientv3.OpPut(hkey, string(offer), clientv3.WithLease(leaseID))).
+		Commit()
+	if err != nil {
+		return nil, authError(err)
+	}
+	if !resp.Succeeded {
+		return nil, HandoffFailure
+	}
+	defer func() {
+		// the outgoing instance deletes it on transfer, unless it never came
+		dctx, cancel := context.WithTimeout(c.Ctx(), time.Duration(defaultTimeout)*time.Second)
+		defer cancel()
+		c.Txn(dctx).
+			If(clientv3.Compare(clientv3.LeaseValue(hkey), "=", leaseID)).
+			Then(clientv3.OpDelete(hkey)).
+			Commit()
+	}()
+
+	for {
+		got, err := c.Get(ctx, key)
+		if err != nil {
+			if cerr := checkContext(ctx); cerr != nil {
+				return nil, cerr
+			}
+			return nil, authError(err)
+		}
+		if len(got.Kvs) == 0 {
+			m, err := Join(c, ctx, leaseID, name, []string{key})
+			if !errors.Is(err, GetIdFailure) {
+				return m, err
+			}
+			continue // claimed by someone else first, see who
+		}
+		kv := got.Kvs[0]
+		if string(kv.Value) == name && clientv3.LeaseID(kv.Lease) == leaseID {
+			return &Member{Key: key, Value: name, LeaseID: leaseID, CreateRevision: kv.CreateRevision}, nil
+		}
+		if err := waitChanged(c, ctx, key, got.Header.Revision+1); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// HandOff gives key, held by name under leaseID, to the instance waiting
+// on it with AwaitHandoff, waiting up to 'timeout' for one to register. The
+// key is transferred with Transfer and the handoff key deleted, and the
+// new owner's Member is returned. If no instance registers in time, or the
+// transfer fails, HandOff falls back to releasing key as ReleaseIf does and
+// returns a nil Member.
+func HandOff(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	name, key string, timeout time.Duration) (*Member, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	hctx, cancel := context.WithTimeout(ctx, timeout)
+	offer, err := waitOffer(c, hctx, HandoffKey(key))
+	cancel()
+	if err == nil {
+		m, err := Transfer(c, ctx, leaseID, name, key, clientv3.LeaseID(offer.LeaseID), offer.Name)
+		if err == nil {
+			if _, err := c.Delete(ctx, HandoffKey(key)); err != nil {
+				return m, authError(err)
+			}
+			return m, nil
+		} else if err == TransferFailure {
+			return nil, err // no longer ours to release
+		}
+	} else if errors.Is(err, ErrAuthExpired) {
+		return nil, err
+	}
+	if _, err := ReleaseIf(c, ctx, leaseID, key, name); err != nil {
+		return nil, authError(err)
+	}
+	return nil, nil
+}
+
+// waitOffer returns the offer under the handoff key, waiting for one to be
+// registered until the context is closed.
+func waitOffer(c *clientv3.Client, ctx context.Context, hkey string) (*handoffOffer, error) {
+	for {
+		got, err := c.Get(ctx, hkey)
+		if err != nil {
+			if cerr := checkContext(ctx); cerr != nil {
+				return nil, cerr
+			}
+			return nil, authError(err)
+		}
+		if len(got.Kvs) > 0 {
+			offer := &handoffOffer{}
+			if err := json.Unmarshal(got.Kvs[0].Value, offer); err != nil {
+				return nil, fmt.Errorf("lock: invalid handoff %q: %v", got.Kvs[0].Value, err)
+			}
+			return offer, nil
+		}
+		if err := waitChanged(c, ctx, hkey, got.Header.Revision+1); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// waitChanged watches the key from rev until it is put or deleted.
+func waitChanged(c *clientv3.Client, ctx context.Context, key string, rev int64) error {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for wresp := range c.Watch(wctx, key, clientv3.WithRev(rev)) {
+		if err := wresp.Err(); err != nil {
+			return err
+		}
+		if len(wresp.Events) > 0 {
+			return nil
+		}
+	}
+	return checkContext(ctx)
+}
@@ -0,0 +1,96 @@
+package stonecutters
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestHandOff(t *testing.T) {
+	key := "/handoff/web-0"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	old, err := client.Grant(ctx, int64(30))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, old.ID)
+	lease, err := client.Grant(ctx, int64(30))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	if _, err := kvPutLease(client, ctx, old.ID, key, "hihi-old"); err != nil {
+		t.Fatalf("error claiming %s: %v", key, err)
+	}
+
+	type result struct {
+		m   *Member
+		err error
+	}
+	waiting := make(chan result, 1)
+	go func() {
+		wctx, wcancel := context.WithTimeout(ctx, 5*time.Second)
+		defer wcancel()
+		m, err := AwaitHandoff(client, wctx, lease.ID, "hihi-new", key)
+		waiting <- result{m, err}
+	}()
+
+	m, err := HandOff(client, ctx, old.ID, "hihi-old", key, 3*time.Second)
+	if err != nil {
+		t.Fatalf("HandOff err: %v", err)
+	}
+	if m == nil || m.Value != "hihi-new" || m.LeaseID != lease.ID {
+		t.Fatalf("key should be handed to the waiter; not: %v", m)
+	}
+	r := <-waiting
+	if r.err != nil {
+		t.Fatalf("AwaitHandoff err: %v", r.err)
+	}
+	if r.m.Key != key || r.m.CreateRevision != m.CreateRevision {
+		t.Errorf("waiter should see the transferred claim %v; not: %v", m, r.m)
+	}
+
+	got, err := client.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientv3.LeaseID(got.Kvs[0].Lease) != lease.ID {
+		t.Errorf("%s should be bound to the new lease", key)
+	}
+	if got, err := client.Get(ctx, HandoffKey(key)); err != nil || len(got.Kvs) != 0 {
+		t.Errorf("handoff key should be deleted: %v %v", got, err)
+	}
+}
+
+func TestHandOffTimeout(t *testing.T) {
+	key := "/handoff/web-1"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(30))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	if _, err := kvPutLease(client, ctx, lease.ID, key, "hihi"); err != nil {
+		t.Fatalf("error claiming %s: %v", key, err)
+	}
+
+	// nobody waiting falls back to a normal release
+	m, err := HandOff(client, ctx, lease.ID, "hihi", key, 200*time.Millisecond)
+	if err != nil || m != nil {
+		t.Fatalf("HandOff with no waiter should release; got: %v %v", m, err)
+	}
+	if got, err := client.Get(ctx, key); err != nil || len(got.Kvs) != 0 {
+		t.Errorf("%s should be released: %v %v", key, got, err)
+	}
+
+	if _, err := Transfer(client, ctx, lease.ID, "hihi", key, lease.ID, "other"); err != TransferFailure {
+		t.Errorf("err[%v] should be TransferFailure", err)
+	}
+}
@@ -0,0 +1,79 @@
+package stonecutters
+
+import (
+	"context"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+var idempotencyPrefix = "idempotency/"
+
+// IdempotencyRecordKey returns the key the record of a claim made with the
+// idempotency key is kept under, out of any pool's range.
+func IdempotencyRecordKey(key string) string {
+	return idempotencyPrefix + key
+}
+
+// WithIdempotencyKey records key alongside the claim Join makes, so that a
+// retried Join with the same key returns the claim already made rather than
+// claiming a second id, eg when an RPC asking for a claim is retried after
+// its response was lost. The record names the id and is written in the
+// claim's Txn under the claim's lease; concurrent retries commit at most one
+// claim between them.
+//
+// The dedup window runs from the claim until the id is released or its
+// lease ends, revoking the record with it; after that the key claims afresh.
+// A retry within the window returns the earlier Member as claimed, under the
+// earlier lease and name, whatever lease and name it was given. Unlike
+// WithIdempotencyToken it needs no Identity in the value and finds the
+// claim with a single read rather than reading every id.
+func WithIdempotencyKey(key string) Option {
+	return func(o *options) {
+		o.idemKey = key
+	}
+}
+
+// idempotencyCmp holds if the idempotency key has no record.
+func (o *options) idempotencyCmp() clientv3.Cmp {
+	return clientv3.Compare(clientv3.Version(IdempotencyRecordKey(o.idemKey)), "=", 0)
+}
+
+// idempotencyOps records a claim of id under the idempotency key, if any.
+func (o *options) idempotencyOps(leaseID clientv3.LeaseID, id string) []clientv3.Op {
+	if o.idemKey == "" {
+		return nil
+	}
+	return []clientv3.Op{clientv3.OpPut(IdempotencyRecordKey(o.idemKey), id, clientv3.WithLease(leaseID))}
+}
+
+// idempotentClaim returns the claim recorded under the idempotency key, or
+// nil if there is none. A record whose claim is no longer held, because its
+// id was released without revoking the lease, is stale and deleted.
+func idempotentClaim(c *clientv3.Client, ctx context.Context, o *options) (*Member, error) {
+	rec := IdempotencyRecordKey(o.idemKey)
+	got, err := c.Get(ctx, rec)
+	if err != nil {
+		return nil, authError(err)
+	}
+	if len(got.Kvs) == 0 {
+		return nil, nil
+	}
+	r := got.Kvs[0]
+	key := string(r.Value)
+	held, err := c.Get(ctx, key)
+	if err != nil {
+		return nil, authError(err)
+	}
+	// written in the same Txn, which created or adopted the key, unless
+	// claimed again since
+	if len(held.Kvs) > 0 && held.Kvs[0].CreateRevision <= r.CreateRevision && held.Kvs[0].Lease == r.Lease {
+		kv := held.Kvs[0]
+		return &Member{Key: key, Value: string(kv.Value), LeaseID: clientv3.LeaseID(kv.Lease),
+			CreateRevision: kv.CreateRevision, ID: o.idOf[key]}, nil
+	}
+	_, err = c.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(rec), "=", r.ModRevision)).
+		Then(clientv3.OpDelete(rec)).
+		Commit()
+	return nil, authError(err)
+}
@@ -0,0 +1,206 @@
+package stonecutters
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestJoinIdempotencyKey(t *testing.T) {
+	prefix := "/idempotent/"
+	ids := PrefixedNumerics(prefix, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	retryLease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, retryLease.ID)
+
+	m, err := Join(client, ctx, lease.ID, "hihi", ids, WithIdempotencyKey("rpc-1"))
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	again, err := Join(client, ctx, retryLease.ID, "hihi-retry", ids, WithIdempotencyKey("rpc-1"))
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if again.Key != m.Key || again.LeaseID != lease.ID || again.CreateRevision != m.CreateRevision {
+		t.Errorf("a retry should return the earlier claim %#v; not: %#v", m, again)
+	}
+	if got, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly()); err != nil || got.Count != 1 {
+		t.Errorf("only one id should be claimed: %v %v", got, err)
+	}
+
+	// released, the window closes
+	if _, err := ReleaseIf(client, ctx, lease.ID, m.Key, "hihi"); err != nil {
+		t.Fatalf("ReleaseIf err: %v", err)
+	}
+	fresh, err := Join(client, ctx, retryLease.ID, "hihi-retry", ids, WithIdempotencyKey("rpc-1"))
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if fresh.LeaseID != retryLease.ID || fresh.CreateRevision == m.CreateRevision {
+		t.Errorf("a released claim should be claimed afresh; not: %#v", fresh)
+	}
+
+	// the record goes with the lease
+	if _, err := client.Revoke(ctx, retryLease.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := client.Get(ctx, IdempotencyRecordKey("rpc-1")); err != nil || len(got.Kvs) != 0 {
+		t.Errorf("the record should be revoked with the lease: %v %v", got, err)
+	}
+}
+
+func TestJoinIdempotencyKeyAdopted(t *testing.T) {
+	prefix := "/idempotent-adopt/"
+	ids := PrefixedNumerics(prefix, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	retryLease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, retryLease.ID)
+	if _, err := kvPutLease(client, ctx, lease.ID, ids[0], "hihi-dead"); err != nil {
+		t.Fatalf("error claiming %s: %v", ids[0], err)
+	}
+
+	// an adopted claim is recorded as a fresh one is
+	opts := []Option{WithAdoptValues("hihi-dead"), WithIdempotencyKey("rpc-adopt")}
+	m, err := Join(client, ctx, lease.ID, "hihi", ids, opts...)
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if m.Key != ids[0] {
+		t.Fatalf("Join should adopt %s; not: %s", ids[0], m.Key)
+	}
+	again, err := Join(client, ctx, retryLease.ID, "hihi-retry", ids, opts...)
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if again.Key != m.Key || again.LeaseID != lease.ID {
+		t.Errorf("a retry should return the adopted claim %#v; not: %#v", m, again)
+	}
+	if got, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly()); err != nil || got.Count != 1 {
+		t.Errorf("only the adopted id should be claimed: %v %v", got, err)
+	}
+}
+
+func TestJoinIdempotencyKeyConcurrent(t *testing.T) {
+	prefix := "/idempotent-concurrent/"
+	ids := PrefixedNumerics(prefix, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	keys := make([]string, 5)
+	var wg sync.WaitGroup
+	for i := range keys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m, err := Join(client, ctx, lease.ID, "hihi", ids, WithIdempotencyKey("rpc-2"))
+			if err != nil {
+				t.Errorf("Join err: %v", err)
+				return
+			}
+			keys[i] = m.Key
+		}(i)
+	}
+	wg.Wait()
+	for _, k := range keys {
+		if k != keys[0] {
+			t.Errorf("every retry should return the same claim: %v", keys)
+			break
+		}
+	}
+	if got, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCountOnly()); err != nil || got.Count != 1 {
+		t.Errorf("only one id should be claimed: %v %v", got, err)
+	}
+}
+
+func TestSessionClaimIdempotencyKey(t *testing.T) {
+	ids := PrefixedNumerics("/idempotent-session/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSession(client)
+	defer s.Close()
+	cl, err := s.Claim(ctx, "hihi", ids, 5, WithIdempotencyKey("rpc-session"))
+	if err != nil {
+		t.Fatalf("Claim err: %v", err)
+	}
+	again, err := s.Claim(ctx, "hihi", ids, 5, WithIdempotencyKey("rpc-session"))
+	if err != nil {
+		t.Fatalf("Claim err: %v", err)
+	}
+	if again != cl {
+		t.Errorf("a retried Claim should return the earlier Claim %#v; not: %#v", cl, again)
+	}
+	if leases := s.Leases(); len(leases) != 1 || leases[0] != cl.LeaseID {
+		t.Errorf("the Session should hold the earlier lease %x alone: %v", cl.LeaseID, leases)
+	}
+	got, err := client.Get(ctx, cl.Key)
+	if err != nil || len(got.Kvs) != 1 || clientv3.LeaseID(got.Kvs[0].Lease) != cl.LeaseID {
+		t.Fatalf("%s should be held under %x: %v %v", cl.Key, cl.LeaseID, got, err)
+	}
+	if err := s.Release(ctx, cl.Key); err != nil {
+		t.Fatalf("Release err: %v", err)
+	}
+	if got, err := client.Get(ctx, cl.Key); err != nil || len(got.Kvs) != 0 {
+		t.Errorf("%s should be released: %v %v", cl.Key, got, err)
+	}
+}
+
+func TestLockerAcquireIdempotencyKey(t *testing.T) {
+	ids := PrefixedNumerics("/idempotent-locker/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := NewLocker(client, ids, WithLeaseTTL(5))
+	id, release, err := l.Acquire(ctx, "hihi", WithIdempotencyKey("rpc-locker"))
+	if err != nil {
+		t.Fatalf("Acquire err: %v", err)
+	}
+	defer release()
+	first, err := client.Get(ctx, id)
+	if err != nil || len(first.Kvs) != 1 {
+		t.Fatalf("%s should be claimed: %v %v", id, first, err)
+	}
+	again, releaseAgain, err := l.Acquire(ctx, "hihi", WithIdempotencyKey("rpc-locker"))
+	if err != nil {
+		t.Fatalf("Acquire err: %v", err)
+	}
+	if again != id {
+		t.Errorf("a retried Acquire should return %s; not: %s", id, again)
+	}
+	if got, err := client.Get(ctx, "/idempotent-locker/", clientv3.WithPrefix(), clientv3.WithCountOnly()); err != nil || got.Count != 1 {
+		t.Errorf("only one id should be claimed: %v %v", got, err)
+	}
+	// the retry's release frees the claim itself, not a lease of its own
+	releaseAgain()
+	if got, err := client.Get(ctx, id); err != nil || len(got.Kvs) != 0 {
+		t.Errorf("%s should be released: %v %v", id, got, err)
+	}
+}
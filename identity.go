@@ -0,0 +1,94 @@
+package stonecutters
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// Identity is the owner recorded as the value of a claimed key: the human
+// name (usually a hostname), a uuid unique to the running instance, an
+// optional stable identity which outlives the instance, when the instance
+// started, any labels describing it and the idempotency token of the claim
+// (see WithIdempotencyToken).
+type Identity struct {
+	Name   string            `json:"name"`
+	UUID   string            `json:"uuid,omitempty"`
+	Stable string            `json:"stable,omitempty"`
+	Since  time.Time         `json:"since"` // omitted when zero, see MarshalJSON
+	Labels map[string]string `json:"labels,omitempty"`
+	Token  string            `json:"token,omitempty"`
+}
+
+// NewIdentity returns an Identity for name with a freshly generated uuid,
+// started now.
+func NewIdentity(name string) *Identity {
+	return &Identity{Name: name, UUID: newUUID(), Since: time.Now().UTC()}
+}
+
+// MarshalJSON encodes the Identity, leaving out a zero Since rather than
+// writing year one, whatever the Go version.
+func (i Identity) MarshalJSON() ([]byte, error) {
+	type fields Identity // without the method
+	var since *time.Time
+	if !i.Since.IsZero() {
+		since = &i.Since
+	}
+	return json.Marshal(struct {
+		fields
+		Since *time.Time `json:"since,omitempty"`
+	}{fields(i), since})
+}
+
+// Encode returns the Identity as the value to store in etcd.
+func (i *Identity) Encode() string {
+	b, _ := json.Marshal(i)
+	return string(b)
+}
+
+// DecodeIdentity parses a value written by Identity.Encode. Values written
+// as a plain owner name, as Join does, decode to an Identity with only the
+// Name set.
+func DecodeIdentity(value string) (*Identity, error) {
+	if !strings.HasPrefix(value, "{") {
+		return &Identity{Name: value}, nil
+	}
+	i := &Identity{}
+	if err := json.Unmarshal([]byte(value), i); err != nil {
+		return nil, fmt.Errorf("lock: invalid identity %q: %v", value, err)
+	}
+	return i, nil
+}
+
+// Identity decodes the member's Value.
+func (m *Member) Identity() (*Identity, error) {
+	return DecodeIdentity(m.Value)
+}
+
+// JoinAs is Join with the encoded Identity as the owner value. The token of
+// WithIdempotencyToken is recorded in the Identity unless it has its own.
+func JoinAs(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	ident *Identity, ids []string, opts ...Option) (*Member, error) {
+	if o := newOptions(opts); o.token != "" && ident.Token == "" {
+		withToken := *ident
+		withToken.Token = o.token
+		ident = &withToken
+	}
+	return Join(c, ctx, leaseID, ident.Encode(), ids, opts...)
+}
+
+// newUUID returns a random (version 4) uuid.
+func newUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,197 @@
+package stonecutters
+
+import (
+	"context"
+	"testing"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestIdentityEncoding(t *testing.T) {
+	a := NewIdentity("homer")
+	b := NewIdentity("homer")
+	if a.UUID == "" || a.UUID == b.UUID {
+		t.Errorf("identities should get distinct uuids: %q %q", a.UUID, b.UUID)
+	}
+
+	a.Labels = map[string]string{"region": "springfield"}
+	got, err := DecodeIdentity(a.Encode())
+	if err != nil {
+		t.Fatalf("error decoding identity: %v", err)
+	}
+	if got.Name != a.Name || got.UUID != a.UUID || got.Labels["region"] != "springfield" {
+		t.Errorf("decoded identity %#v does not match %#v", got, a)
+	}
+
+	if got.Since.IsZero() || !got.Since.Equal(a.Since) {
+		t.Errorf("decoded Since %v should be %v", got.Since, a.Since)
+	}
+	if enc := (&Identity{Name: "homer"}).Encode(); enc != `{"name":"homer"}` {
+		t.Errorf("a zero Since should be left out: %s", enc)
+	}
+
+	plain, err := DecodeIdentity("wyeast")
+	if err != nil {
+		t.Fatalf("error decoding plain name: %v", err)
+	}
+	if plain.Name != "wyeast" || plain.UUID != "" {
+		t.Errorf("plain name should decode to only a Name: %#v", plain)
+	}
+
+	if _, err := DecodeIdentity("{not json"); err == nil {
+		t.Errorf("invalid identity should not decode")
+	}
+}
+
+func TestJoinAs(t *testing.T) {
+	ids := []string{"ident-a", "ident-b"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(5))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	ident := NewIdentity("homer")
+	if _, err := JoinAs(client, ctx, lease.ID, ident, ids); err != nil {
+		t.Fatalf("JoinAs err: %v", err)
+	}
+
+	members, err := Members(client, ids)
+	if err != nil {
+		t.Fatalf("error listing members: %v", err)
+	}
+	if len(members) != 1 {
+		t.Fatalf("members returned should be 1; not: %d", len(members))
+	}
+	got, err := members[0].Identity()
+	if err != nil {
+		t.Fatalf("error decoding member identity: %v", err)
+	}
+	if got.Name != "homer" || got.UUID != ident.UUID {
+		t.Errorf("member identity %#v does not match %#v", got, ident)
+	}
+}
+
+func TestJoinAffinity(t *testing.T) {
+	ids := PrefixedNumerics("/affinity/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	old, err := client.Grant(ctx, int64(30))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, old.ID)
+	lease, err := client.Grant(ctx, int64(30))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	// the old pod held the second id; the first is free
+	before := NewIdentity("pod-abc12")
+	before.Stable = "web-1"
+	if _, err := kvPutLease(client, ctx, old.ID, ids[1], before.Encode()); err != nil {
+		t.Fatalf("error claiming %s: %v", ids[1], err)
+	}
+
+	after := NewIdentity("pod-xyz89")
+	after.Stable = "web-1"
+	mem, err := JoinAs(client, ctx, lease.ID, after, ids, WithAffinity(after.Stable))
+	if err != nil {
+		t.Fatalf("JoinAs err: %v", err)
+	}
+	if mem.Key != ids[1] {
+		t.Errorf("restarted pod should reclaim %s; not: %s", ids[1], mem.Key)
+	}
+	got, err := client.Get(ctx, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientv3.LeaseID(got.Kvs[0].Lease) != lease.ID || string(got.Kvs[0].Value) != after.Encode() {
+		t.Errorf("%s should be rebound to the new lease and identity", ids[1])
+	}
+
+	other := NewIdentity("pod-def34")
+	other.Stable = "web-2"
+	mem, err = JoinAs(client, ctx, lease.ID, other, ids, WithAffinity(other.Stable))
+	if err != nil {
+		t.Fatalf("JoinAs err: %v", err)
+	}
+	if mem.Key != ids[0] {
+		t.Errorf("no affinity match should fall back to %s; not: %s", ids[0], mem.Key)
+	}
+}
+
+func TestJoinAdoptValues(t *testing.T) {
+	ids := PrefixedNumerics("/adopt/", 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	old, err := client.Grant(ctx, int64(30))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, old.ID)
+	lease, err := client.Grant(ctx, int64(30))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	// written in the old plain-name format
+	if _, err := kvPutLease(client, ctx, old.ID, ids[1], "homer"); err != nil {
+		t.Fatalf("error claiming %s: %v", ids[1], err)
+	}
+	mem, err := JoinAs(client, ctx, lease.ID, NewIdentity("homer"), ids, WithAdoptValues("homer"))
+	if err != nil {
+		t.Fatalf("JoinAs err: %v", err)
+	}
+	if mem.Key != ids[1] {
+		t.Errorf("old format claim %s should be re-adopted; not: %s", ids[1], mem.Key)
+	}
+}
+
+func TestJoinIdempotencyToken(t *testing.T) {
+	ids := PrefixedNumerics("/idempotent/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(30))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	ident := NewIdentity("homer")
+	first, err := JoinAs(client, ctx, lease.ID, ident, ids, WithIdempotencyToken("claim-1"))
+	if err != nil {
+		t.Fatalf("JoinAs err: %v", err)
+	}
+
+	// as if the first response was lost and the claim retried
+	retried, err := JoinAs(client, ctx, lease.ID, ident, ids, WithIdempotencyToken("claim-1"))
+	if err != nil {
+		t.Fatalf("JoinAs err: %v", err)
+	}
+	if retried.Key != first.Key {
+		t.Errorf("retried claim should adopt %s; not: %s", first.Key, retried.Key)
+	}
+	if got, err := retried.Identity(); err != nil || got.Token != "claim-1" {
+		t.Errorf("token should be recorded in the identity: %#v %v", got, err)
+	}
+
+	other, err := JoinAs(client, ctx, lease.ID, ident, ids, WithIdempotencyToken("claim-2"))
+	if err != nil {
+		t.Fatalf("JoinAs err: %v", err)
+	}
+	if other.Key == first.Key {
+		t.Errorf("a different token should not adopt %s", first.Key)
+	}
+	if ident.Token != "" {
+		t.Errorf("JoinAs should not modify the caller's identity: %#v", ident)
+	}
+}
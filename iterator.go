@@ -0,0 +1,100 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// defaultIteratorBatch is how many candidates GetIDFromIterator pulls from
+// the iterator for each Join.
+var defaultIteratorBatch = 64
+
+// IDIterator returns the next candidate id and true, or false once there
+// are no more. Unlike an id slice it needn't hold the pool in memory.
+type IDIterator func() (string, bool)
+
+// IterateIDs returns an IDIterator over an id slice.
+func IterateIDs(ids []string) IDIterator {
+	i := 0
+	return func() (string, bool) {
+		if i >= len(ids) {
+			return "", false
+		}
+		i++
+		return ids[i-1], true
+	}
+}
+
+// PrefixedRange returns an IDIterator over prefix followed by each integer
+// from 'from' to 'to' inclusive, eg "node-0" to "node-1000000", without
+// materializing them as PrefixedNumerics does.
+func PrefixedRange(prefix string, from, to int) IDIterator {
+	i := from
+	return func() (string, bool) {
+		if i > to {
+			return "", false
+		}
+		i++
+		return fmt.Sprintf("%s%d", prefix, i-1), true
+	}
+}
+
+// GetIDFromIterator claims the first free id the iterator yields, as Join
+// does for a slice, for virtual pools too large to materialize. The
+// iterator is consumed in small batches, each Joined in turn, until a claim
+// succeeds or the iterator is exhausted, in which case a PoolExhaustedError
+// is returned, or ErrPoolEmpty if it yielded no ids at all. Only the ids of
+// the batch being claimed are held in memory.
+//
+// Honours the options of Join, applied to each batch: WithAffinity and
+// WithAdoptValues only adopt an earlier claim among the ids of the batch.
+// Under ErrorsReport a batch failing on an error, rather than an answer
+// from etcd, counts its ids as failed and iteration moves on; the first
+// such error is returned only once the iterator is exhausted.
+func GetIDFromIterator(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	name string, next IDIterator, opts ...Option) (*Member, error) {
+	o := newOptions(opts)
+	batch := make([]string, 0, defaultIteratorBatch)
+	exhausted := &PoolExhaustedError{}
+	var firstErr error
+	for {
+		batch = batch[:0]
+		for len(batch) < defaultIteratorBatch {
+			id, ok := next()
+			if !ok {
+				break
+			}
+			batch = append(batch, id)
+		}
+		if len(batch) == 0 {
+			if exhausted.Size == 0 {
+				return nil, ErrPoolEmpty
+			}
+			if firstErr != nil {
+				return nil, firstErr
+			}
+			return nil, exhausted
+		}
+		m, err := Join(c, ctx, leaseID, name, batch, opts...)
+		var pe *PoolExhaustedError
+		if err != nil && !errors.As(err, &pe) && o.errPolicy == ErrorsReport &&
+			unreachable(err) && checkContext(ctx) == nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			exhausted.Size += len(batch)
+			exhausted.Failed += len(batch)
+			continue
+		}
+		if !errors.As(err, &pe) {
+			return m, err
+		}
+		exhausted.Size += pe.Size
+		exhausted.Conflicts += pe.Conflicts
+		exhausted.Filtered += pe.Filtered
+		exhausted.Failed += pe.Failed
+	}
+}
@@ -0,0 +1,126 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestPrefixedRange(t *testing.T) {
+	next := PrefixedRange("node-", 0, 2)
+	for _, want := range []string{"node-0", "node-1", "node-2"} {
+		if id, ok := next(); !ok || id != want {
+			t.Errorf("next id should be %q; not: %q %v", want, id, ok)
+		}
+	}
+	if id, ok := next(); ok {
+		t.Errorf("range should be exhausted; got: %q", id)
+	}
+}
+
+func TestGetIDFromIterator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	// fill more than a batch so the claim comes from a later one
+	taken := defaultIteratorBatch + 3
+	for _, id := range PrefixedNumerics("/iterator/", taken) {
+		if _, err := kvPutLease(client, ctx, lease.ID, id, "other"); err != nil {
+			t.Fatalf("error claiming %s: %v", id, err)
+		}
+	}
+	m, err := GetIDFromIterator(client, ctx, lease.ID, "hihi", PrefixedRange("/iterator/", 1, 1000000))
+	if err != nil {
+		t.Fatalf("GetIDFromIterator err: %v", err)
+	}
+	if want := PrefixedNumerics("/iterator/", taken+1)[taken]; m.Key != want {
+		t.Errorf("should claim the first free id %s; not: %s", want, m.Key)
+	}
+
+	if _, err := GetIDFromIterator(client, ctx, lease.ID, "hihi", IterateIDs([]string{m.Key})); !errors.Is(err, GetIdFailure) {
+		t.Errorf("exhausted iterator err[%v] should be GetIdFailure", err)
+	}
+}
+
+// flakyKV fails the first 'failures' Txns it commits.
+type flakyKV struct {
+	clientv3.KV
+	failures int64
+}
+
+func (kv *flakyKV) Txn(ctx context.Context) clientv3.Txn {
+	return &flakyTxn{Txn: kv.KV.Txn(ctx), kv: kv}
+}
+
+type flakyTxn struct {
+	clientv3.Txn
+	kv *flakyKV
+}
+
+func (t *flakyTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	t.Txn = t.Txn.If(cs...)
+	return t
+}
+
+func (t *flakyTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	t.Txn = t.Txn.Then(ops...)
+	return t
+}
+
+func (t *flakyTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	t.Txn = t.Txn.Else(ops...)
+	return t
+}
+
+var errFlaky = errors.New("flaky txn")
+
+func (t *flakyTxn) Commit() (*clientv3.TxnResponse, error) {
+	if atomic.AddInt64(&t.kv.failures, -1) >= 0 {
+		return nil, errFlaky
+	}
+	return t.Txn.Commit()
+}
+
+func TestGetIDFromIteratorFlakyBatch(t *testing.T) {
+	ids := PrefixedNumerics("/iterator-flaky/", defaultIteratorBatch+1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := clientv3.New(clientv3.Config{Endpoints: client.Endpoints(), DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer c.Close()
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	// the whole first batch fails, the next one claims
+	c.KV = &flakyKV{KV: c.KV, failures: int64(defaultIteratorBatch)}
+	report := WithErrorPolicy(ErrorsReport)
+	m, err := GetIDFromIterator(c, ctx, lease.ID, "hihi", IterateIDs(ids), report)
+	if err != nil {
+		t.Fatalf("GetIDFromIterator should move past a failed batch: %v", err)
+	}
+	if want := ids[defaultIteratorBatch]; m.Key != want {
+		t.Errorf("should claim %s from the second batch; not: %s", want, m.Key)
+	}
+
+	// the first error is returned once the iterator runs out
+	c.KV = &flakyKV{KV: c.KV, failures: 1}
+	if _, err := GetIDFromIterator(c, ctx, lease.ID, "hihi", IterateIDs(ids[:1]), report); err != errFlaky {
+		t.Errorf("err[%v] should be the batch's error once exhausted", err)
+	}
+}
@@ -0,0 +1,166 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// KeepAlive keeps leaseID alive until the context or the Session is closed,
+// consuming etcd's keepalive stream so the caller needn't. The returned
+// channel receives the outcome once the lease is no longer kept alive, then
+// closes: nil if the context or Session closed, or LeaseLostFailure if etcd
+// stopped renewing the lease.
+//
+// The stream doesn't survive every reconnect of the client, though the
+// lease may well outlive it. When the stream closes, KeepAlive checks the
+// lease with TimeToLive and, if it still has time left, resumes keeping it
+// alive on a new stream rather than reporting it lost.
+func (s *Session) KeepAlive(ctx context.Context, leaseID clientv3.LeaseID) (<-chan error, error) {
+	if s.ctx.Err() != nil {
+		return nil, SessionClosedFailure
+	}
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	kctx, cancel := context.WithCancel(ctx)
+	ch, err := s.c.KeepAlive(kctx, leaseID)
+	if err != nil {
+		cancel()
+		return nil, authError(err)
+	}
+	lost := make(chan error, 1)
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			cancel()
+		case <-kctx.Done():
+		}
+	}()
+	go func() {
+		defer cancel()
+		lost <- s.keepAlive(kctx, leaseID, ch)
+		close(lost)
+	}()
+	return lost, nil
+}
+
+// keepAlive drains the keepalive stream of leaseID, resuming it with
+// resumeKeepAlive each time it closes while the lease is still alive. A
+// resumed stream which closes before renewing the lease even once is not
+// resumed again, so a client which can no longer keep leases alive isn't
+// retried forever.
+func (s *Session) keepAlive(ctx context.Context, leaseID clientv3.LeaseID,
+	ch <-chan *clientv3.LeaseKeepAliveResponse) error {
+	renewed := true
+	for {
+		err := drainKeepAlive(ctx, ch, func(int64) { renewed = true })
+		if err == nil || !renewed {
+			return err
+		}
+		renewed = false
+		if ch, err = resumeKeepAlive(s.c, ctx, leaseID); err != nil || ch == nil {
+			return err
+		}
+	}
+}
+
+// resumeKeepAlive opens a new keepalive stream on leaseID if etcd still has
+// the lease with time left on it; otherwise returns LeaseLostFailure. Nil
+// is returned with a nil channel if the context closes first.
+func resumeKeepAlive(c *clientv3.Client, ctx context.Context,
+	leaseID clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	resp, err := c.TimeToLive(ctx, leaseID)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		if err := authError(err); errors.Is(err, ErrAuthExpired) {
+			return nil, err
+		}
+		return nil, LeaseLostFailure
+	}
+	if resp.TTL <= 0 {
+		return nil, LeaseLostFailure
+	}
+	ch, err := c.KeepAlive(ctx, leaseID)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		return nil, LeaseLostFailure
+	}
+	return ch, nil
+}
+
+// drainKeepAlive consumes the responses of a KeepAlive, calling onRenew (if
+// not nil) with the TTL of each, until the context is closed or the lease is
+// lost. Returns nil for the former and LeaseLostFailure for the latter:
+// the stream closing while the context is open, or a response reporting the
+// lease gone.
+func drainKeepAlive(ctx context.Context, ch <-chan *clientv3.LeaseKeepAliveResponse,
+	onRenew func(ttl int64)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case resp, ok := <-ch:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return LeaseLostFailure
+			}
+			if keepAliveLost(resp) {
+				return LeaseLostFailure
+			}
+			if onRenew != nil {
+				onRenew(resp.TTL)
+			}
+		}
+	}
+}
+
+// keepAliveLost returns true if the keepalive response reports the lease
+// gone. etcd can answer a keepalive on a lease revoked server side with a
+// TTL of zero rather than an error.
+func keepAliveLost(resp *clientv3.LeaseKeepAliveResponse) bool {
+	return resp == nil || resp.TTL <= 0
+}
+
+// RenewLeases renews every lease once, as KeepAliveOnce does, concurrently
+// so that one dead or slow lease doesn't hold up the others. Returns the
+// leases which failed to renew with their errors, or nil if all renewed;
+// a lease etcd no longer has fails with rpctypes.ErrLeaseNotFound or
+// LeaseLostFailure, and the ids claimed under it should be claimed afresh.
+func RenewLeases(c *clientv3.Client, ctx context.Context, leases []clientv3.LeaseID) map[clientv3.LeaseID]error {
+	errs := make([]error, len(leases))
+	var wg sync.WaitGroup
+	for i, leaseID := range leases {
+		wg.Add(1)
+		go func(i int, leaseID clientv3.LeaseID) {
+			defer wg.Done()
+			resp, err := c.KeepAliveOnce(ctx, leaseID)
+			if err != nil {
+				errs[i] = authError(err)
+			} else if keepAliveLost(resp) {
+				errs[i] = LeaseLostFailure
+			}
+		}(i, leaseID)
+	}
+	wg.Wait()
+
+	var failed map[clientv3.LeaseID]error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if failed == nil {
+			failed = make(map[clientv3.LeaseID]error)
+		}
+		failed[leases[i]] = err
+	}
+	return failed
+}
@@ -0,0 +1,136 @@
+package stonecutters
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestDrainKeepAlive(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := make(chan *clientv3.LeaseKeepAliveResponse, 2)
+	ch <- &clientv3.LeaseKeepAliveResponse{ID: 1, TTL: 5}
+	ch <- &clientv3.LeaseKeepAliveResponse{ID: 1, TTL: 0}
+	var renewed []int64
+	if err := drainKeepAlive(ctx, ch, func(ttl int64) { renewed = append(renewed, ttl) }); err != LeaseLostFailure {
+		t.Errorf("a TTL 0 keepalive err[%v] should be LeaseLostFailure", err)
+	}
+	if len(renewed) != 1 || renewed[0] != 5 {
+		t.Errorf("only the live keepalive should be a renewal: %v", renewed)
+	}
+
+	closed := make(chan *clientv3.LeaseKeepAliveResponse)
+	close(closed)
+	if err := drainKeepAlive(ctx, closed, nil); err != LeaseLostFailure {
+		t.Errorf("a closed stream err[%v] should be LeaseLostFailure", err)
+	}
+
+	cancel()
+	if err := drainKeepAlive(ctx, make(chan *clientv3.LeaseKeepAliveResponse), nil); err != nil {
+		t.Errorf("a closed context should not be loss: %v", err)
+	}
+}
+
+func TestSessionKeepAlive(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSession(client)
+	defer s.Close()
+	lease, err := client.Grant(ctx, int64(2))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	lost, err := s.KeepAlive(ctx, lease.ID)
+	if err != nil {
+		t.Fatalf("KeepAlive err: %v", err)
+	}
+
+	select {
+	case err := <-lost:
+		t.Fatalf("lease should be kept alive past its TTL: %v", err)
+	case <-time.After(3 * time.Second):
+	}
+	expireLease(t, lease.ID)
+	select {
+	case err := <-lost:
+		if err != LeaseLostFailure {
+			t.Errorf("err[%v] should be LeaseLostFailure", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expired lease should be reported lost")
+	}
+}
+
+func TestRenewLeases(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leases := make([]clientv3.LeaseID, 0, 3)
+	for i := 0; i < 3; i++ {
+		lease, err := client.Grant(ctx, int64(10))
+		if err != nil {
+			t.Fatalf("error creating lease: %v", err)
+		}
+		defer client.Revoke(ctx, lease.ID)
+		leases = append(leases, lease.ID)
+	}
+	expireLease(t, leases[1])
+
+	failed := RenewLeases(client, ctx, leases)
+	if len(failed) != 1 || failed[leases[1]] == nil {
+		t.Fatalf("only the expired lease should fail: %v", failed)
+	}
+	for _, leaseID := range []clientv3.LeaseID{leases[0], leases[2]} {
+		ttl, err := client.TimeToLive(ctx, leaseID)
+		if err != nil || ttl.TTL <= 0 {
+			t.Errorf("lease %x should still be renewed: %v %v", int64(leaseID), ttl, err)
+		}
+	}
+}
+
+func TestSessionKeepAliveReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a client of its own, so the reconnect doesn't disturb other tests
+	c, err := clientv3.New(clientv3.Config{Endpoints: client.Endpoints(), DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer c.Close()
+
+	s := NewSession(c)
+	defer s.Close()
+	lease, err := c.Grant(ctx, int64(2))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	m, err := Join(c, ctx, lease.ID, "hihi", PrefixedNumerics("/reconnect/", 2))
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	lost, err := s.KeepAlive(ctx, lease.ID)
+	if err != nil {
+		t.Fatalf("KeepAlive err: %v", err)
+	}
+
+	// a reconnect leaves the lease valid but its keepalive stream gone
+	old := c.Lease
+	c.Lease = clientv3.NewLease(c)
+	old.Close()
+
+	select {
+	case err := <-lost:
+		t.Fatalf("lease should be kept alive across the reconnect: %v", err)
+	case <-time.After(5 * time.Second):
+	}
+	if !verifyKvPair(client, m.Key, m.Value) {
+		t.Errorf("claim %s should survive the reconnect", m.Key)
+	}
+}
@@ -0,0 +1,54 @@
+package stonecutters
+
+import (
+	"context"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// grantLease grants a lease of ttl seconds, returning early if the context
+// closes first. The Grant itself is not bound to the context: had the
+// context cancelled it in flight after etcd created the lease, the lease
+// would be left behind with no one knowing its id. Instead a grant which
+// completes after the caller gave up is revoked.
+func grantLease(c *clientv3.Client, ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	type result struct {
+		lease *clientv3.LeaseGrantResponse
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		gctx, cancel := context.WithTimeout(c.Ctx(), time.Duration(defaultTimeout)*time.Second)
+		defer cancel()
+		lease, err := c.Grant(gctx, ttl)
+		done <- result{lease, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, authError(r.err)
+		}
+		return r.lease, nil
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.err == nil {
+				abandonLease(c, r.lease.ID)
+			}
+		}()
+		return nil, checkContext(ctx)
+	}
+}
+
+// abandonLease revokes a lease nothing was claimed under, best effort. It
+// is not bound to the caller's context, which may be why the lease is being
+// abandoned.
+func abandonLease(c *clientv3.Client, leaseID clientv3.LeaseID) {
+	ctx, cancel := context.WithTimeout(c.Ctx(), time.Duration(defaultTimeout)*time.Second)
+	defer cancel()
+	revokeLease(c, ctx, leaseID)
+}
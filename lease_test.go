@@ -0,0 +1,46 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGrantLeaseCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	before, err := client.Leases(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// contexts closing around the time etcd answers the Grant
+	for i := 0; i < 20; i++ {
+		gctx, gcancel := context.WithTimeout(ctx, time.Duration(i*100)*time.Microsecond)
+		lease, err := grantLease(client, gctx, 10)
+		gcancel()
+		if err == nil {
+			client.Revoke(ctx, lease.ID)
+		} else if !errors.Is(err, ContextDoneFailure) {
+			t.Fatalf("grantLease err: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		after, err := client.Leases(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(after.Leases) <= len(before.Leases) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("leases granted after their context closed should be revoked; %d leaked",
+				len(after.Leases)-len(before.Leases))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
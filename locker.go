@@ -0,0 +1,327 @@
+package stonecutters
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+var defaultLeaseTTL = int64(10)
+
+// Locker claims ids from a fixed list on behalf of a process, applying the
+// same options to every claim. Process wide state, such as the rate limit,
+// lives on the Locker and is shared by all of its calls.
+//
+// A Locker is safe for concurrent use: any number of goroutines may Join or
+// Acquire through one Locker, even under one shared lease, and each ends up
+// with an id of its own.
+type Locker struct {
+	c    *clientv3.Client
+	ids  []string
+	opts []Option
+
+	limiter *tokenBucket // nil when unlimited
+
+	mu          sync.Mutex
+	cfg         *PoolConfig       // nil until LoadConfig
+	prefix      string            // of the config
+	held        map[string]heldID // claims made and not yet released, by key
+	unconfirmed int               // fallback ids Acquired without a claim
+	lastLatency time.Duration
+}
+
+// NewLocker returns a Locker claiming from ids with the options applied to
+// every call.
+//
+// Honours WithRateLimit along with any option of the calls it makes.
+func NewLocker(c *clientv3.Client, ids []string, opts ...Option) *Locker {
+	l := &Locker{c: c, ids: ids, opts: opts, held: make(map[string]heldID)}
+	if o := newOptions(opts); o.rate > 0 {
+		l.limiter = newTokenBucket(o.rate, o.burst, o.clock)
+	}
+	return l
+}
+
+// heldID is a claim counted by a Locker.
+type heldID struct {
+	id      string
+	leaseID clientv3.LeaseID
+}
+
+// Join claims one of the Locker's ids under the lease, see Join. Options
+// given here are applied after the Locker's. The claim counts towards
+// Status and Held until released with Release; the Locker doesn't watch
+// the lease, which may be shared with other claims.
+func (l *Locker) Join(ctx context.Context, leaseID clientv3.LeaseID, name string, opts ...Option) (*Member, error) {
+	var m *Member
+	err := l.claim(ctx, func(ids []string) error {
+		var err error
+		m, err = Join(l.c, ctx, leaseID, name, ids, l.options(opts)...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	l.hold(m)
+	return m, nil
+}
+
+// Release frees a claim made by Join, if still held by m, see ReleaseIf,
+// and stops counting it.
+func (l *Locker) Release(ctx context.Context, m *Member, opts ...Option) (bool, error) {
+	ok, err := ReleaseIf(l.c, ctx, m.LeaseID, m.Key, m.Value, opts...)
+	if err != nil {
+		return false, err
+	}
+	l.unhold(m.Key, m.LeaseID)
+	return ok, nil
+}
+
+// hold counts the claim m.
+func (l *Locker) hold(m *Member) {
+	l.mu.Lock()
+	l.held[m.Key] = heldID{id: m.id(), leaseID: m.LeaseID}
+	l.mu.Unlock()
+}
+
+// unhold stops counting the claim of key, unless it has since been claimed
+// again under another lease.
+func (l *Locker) unhold(key string, leaseID clientv3.LeaseID) {
+	l.mu.Lock()
+	if h, ok := l.held[key]; ok && h.leaseID == leaseID {
+		delete(l.held, key)
+	}
+	l.mu.Unlock()
+}
+
+// Acquire claims one of the Locker's ids under a lease of its own, kept
+// alive until release is called or the context is closed, whichever comes
+// first; either revokes the lease, freeing the id. release is safe to call
+// any number of times, including after the context closed, so it can be
+// deferred:
+//
+//	id, release, err := locker.Acquire(ctx, name)
+//	if err != nil {
+//		return err
+//	}
+//	defer release()
+//
+// Honours WithLeaseTTL, WithTTLFunc, WithMinTTL and WithFallbackID along
+// with the options of Join; WithMetrics measures the lease grant too.
+func (l *Locker) Acquire(ctx context.Context, name string, opts ...Option) (id string, release func(), err error) {
+	all := l.options(opts)
+	o := newOptions(all)
+	if o.fallbackID == "" {
+		return l.acquire(ctx, ctx, name, all, o, nil)
+	}
+	return l.acquireOrFallback(ctx, name, all, o)
+}
+
+// acquire is Acquire, claiming within claimCtx, which may close before ctx,
+// from ids, or the Locker's pool if nil.
+func (l *Locker) acquire(ctx, claimCtx context.Context, name string, all []Option, o *options,
+	ids []string) (string, func(), error) {
+	start := o.clock.Now()
+	if err := checkContext(claimCtx); err != nil {
+		return "", nil, err
+	}
+	all = append(all[:len(all):len(all)], withoutMetrics())
+	ttl := o.leaseTTL
+	if ttl == 0 {
+		ttl = defaultLeaseTTL
+	}
+	var m *Member
+	var lease *clientv3.LeaseGrantResponse
+	join := func(ids []string) error {
+		var err error
+		m, lease, err = grantAndJoin(l.c, claimCtx, name, ids, ttl, o, all)
+		return err
+	}
+	var err error
+	if ids != nil {
+		err = join(ids)
+	} else {
+		err = l.claim(claimCtx, join)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	kctx, cancel := context.WithCancel(ctx)
+	var once sync.Once
+	var acquired bool
+	end := func(action AuditAction) {
+		once.Do(func() {
+			cancel()
+			if acquired {
+				l.unhold(m.Key, lease.ID)
+			}
+			abandonLease(l.c, lease.ID)
+			o.audited(action, m)
+		})
+	}
+	release := func() { end(AuditReleased) }
+
+	keepalive, err := l.c.KeepAlive(kctx, lease.ID)
+	if err != nil {
+		release()
+		return "", nil, authError(err)
+	}
+	latency := o.observeClaim(start)
+	l.hold(m)
+	l.mu.Lock()
+	l.lastLatency = latency
+	l.mu.Unlock()
+	acquired = true
+	go func() {
+		// the context closed, or the lease is gone
+		if err := drainKeepAlive(kctx, keepalive, nil); err != nil {
+			end(AuditLost)
+		} else {
+			release()
+		}
+	}()
+	return m.id(), release, nil
+}
+
+// Status returns the number of ids Joined or Acquired and not yet
+// released, the fallback ids in use without a claim, and the latency of
+// the latest Acquire, lease grant included.
+func (l *Locker) Status() Status {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Status{Held: len(l.held), Unconfirmed: l.unconfirmed, LastClaimLatency: l.lastLatency}
+}
+
+// Held returns the ids Joined or Acquired and not yet released, sorted.
+func (l *Locker) Held() []string {
+	l.mu.Lock()
+	ids := make([]string, 0, len(l.held))
+	for _, h := range l.held {
+		ids = append(ids, h.id)
+	}
+	l.mu.Unlock()
+	sort.Strings(ids)
+	return ids
+}
+
+// WithLeaseTTL sets the TTL, in seconds, of the lease Acquire claims under.
+// Defaults to 10s.
+func WithLeaseTTL(seconds int64) Option {
+	return func(o *options) {
+		o.leaseTTL = seconds
+	}
+}
+
+// LoadConfig loads the PoolConfig of the pool under prefix, see
+// LoadPoolConfig, for the Locker to honour from then on: only the first
+// MaxSize of its ids are claimed, growing to them if AutoGrow is set, and
+// DefaultTTL is the lease TTL of Acquire. Options given to NewLocker or a
+// call override the config. Call again to pick up changes.
+func (l *Locker) LoadConfig(ctx context.Context, prefix string) (*PoolConfig, error) {
+	cfg, err := LoadPoolConfig(l.c, ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	l.cfg, l.prefix = cfg, prefix
+	l.mu.Unlock()
+	return cfg, nil
+}
+
+// pool returns the ids to claim from, as limited by the config.
+func (l *Locker) pool() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cfg != nil && l.cfg.MaxSize > 0 && l.cfg.MaxSize < len(l.ids) {
+		return l.ids[:l.cfg.MaxSize]
+	}
+	return l.ids
+}
+
+// claim calls join with the ids to claim from, growing the pool if the
+// config says to.
+func (l *Locker) claim(ctx context.Context, join func(ids []string) error) error {
+	ids := l.pool()
+	l.mu.Lock()
+	cfg, prefix := l.cfg, l.prefix
+	l.mu.Unlock()
+	if cfg == nil || !cfg.AutoGrow {
+		return join(ids)
+	}
+	return growPool(l.c, ctx, prefix, cfg.InitialSize, len(ids), func(size int) error {
+		return join(ids[:size])
+	})
+}
+
+func (l *Locker) options(opts []Option) []Option {
+	all := make([]Option, 0, len(l.opts)+len(opts)+2)
+	l.mu.Lock()
+	if l.cfg != nil && l.cfg.DefaultTTL > 0 {
+		all = append(all, WithLeaseTTL(l.cfg.DefaultTTL))
+	}
+	l.mu.Unlock()
+	all = append(all, l.opts...)
+	all = append(all, opts...)
+	if l.limiter != nil {
+		all = append(all, func(o *options) { o.limiter = l.limiter })
+	}
+	return all
+}
+
+// WithRateLimit caps a Locker at 'perSecond' claim attempts across all of
+// its calls, allowing bursts of up to 'burst'. Once the budget is spent
+// claims wait for it to refill, or for their context to close, rather than
+// failing. Smooths the herd of claims when many processes restart at once.
+// Unlimited by default.
+func WithRateLimit(perSecond float64, burst int) Option {
+	return func(o *options) {
+		o.rate = perSecond
+		o.burst = burst
+	}
+}
+
+// tokenBucket is a simple token-bucket rate limiter.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+	clock  clock
+}
+
+func newTokenBucket(rate float64, burst int, clk clock) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: clk.Now(), clock: clk}
+}
+
+// wait blocks until a token is available, or the context is closed.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := b.clock.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		need := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return checkContext(ctx)
+		case <-b.clock.After(need):
+		}
+	}
+}
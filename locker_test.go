@@ -0,0 +1,214 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestTokenBucket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := newTokenBucket(20, 1, realClock{})
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := b.wait(ctx); err != nil {
+			t.Fatalf("wait err: %v", err)
+		}
+	}
+	// one from the burst then four at 50ms each
+	if el := time.Since(start); el < 190*time.Millisecond {
+		t.Errorf("5 waits at 20/s should take at least 200ms; took %v", el)
+	}
+
+	cancel()
+	if err := b.wait(ctx); !errors.Is(err, ContextDoneFailure) {
+		t.Errorf("wait on a closed context err[%v] should be ContextDoneFailure", err)
+	}
+}
+
+func TestLockerRateLimit(t *testing.T) {
+	ids := PrefixedNumerics("/locker/", 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	l := NewLocker(client, ids, WithRateLimit(10, 1))
+	start := time.Now()
+	for i := range ids {
+		mem, err := l.Join(ctx, lease.ID, "hihi")
+		if err != nil {
+			t.Fatalf("Locker Join err: %v", err)
+		}
+		if mem.Key != ids[i] {
+			t.Errorf("claim %d should be %s; not: %s", i, ids[i], mem.Key)
+		}
+	}
+	// 1+2+3+4 attempts, the first from the burst
+	if el := time.Since(start); el < 850*time.Millisecond {
+		t.Errorf("10 attempts at 10/s should take at least 900ms; took %v", el)
+	}
+}
+
+func TestLockerAcquire(t *testing.T) {
+	ids := PrefixedNumerics("/acquire-locker/", 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := NewLocker(client, ids, WithLeaseTTL(5))
+	id, release, err := l.Acquire(ctx, "hihi")
+	if err != nil {
+		t.Fatalf("Acquire err: %v", err)
+	}
+	if id != ids[0] {
+		t.Errorf("Acquire should be assigned %s; not: %s", ids[0], id)
+	}
+	release()
+	release()
+	if got, err := client.Get(ctx, id); err != nil || len(got.Kvs) != 0 {
+		t.Errorf("%s should be released: %v %v", id, got, err)
+	}
+
+	actx, acancel := context.WithCancel(ctx)
+	id, release, err = l.Acquire(actx, "hihi")
+	if err != nil {
+		t.Fatalf("Acquire err: %v", err)
+	}
+	defer release()
+	acancel()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		got, err := client.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Kvs) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s should be released once the context closed", id)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestLockerConcurrent(t *testing.T) {
+	const workers = 20
+	ids := PrefixedNumerics("/concurrent-locker/", 2*workers+5)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	l := NewLocker(client, ids, WithLeaseTTL(5))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	joined := make(map[string]int)
+	acquired := make(map[string]int)
+	releases := make([]func(), 0, workers)
+	members := make([]*Member, 0, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(2)
+		// half share one lease, half Acquire leases of their own
+		go func() {
+			defer wg.Done()
+			m, err := l.Join(ctx, lease.ID, "hihi")
+			if err != nil {
+				t.Errorf("Join err: %v", err)
+				return
+			}
+			mu.Lock()
+			joined[m.Key]++
+			members = append(members, m)
+			mu.Unlock()
+		}()
+		go func() {
+			defer wg.Done()
+			id, release, err := l.Acquire(ctx, "hihi")
+			if err != nil {
+				t.Errorf("Acquire err: %v", err)
+				return
+			}
+			mu.Lock()
+			acquired[id]++
+			releases = append(releases, release)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	for id, n := range joined {
+		if n > 1 || acquired[id] > 0 {
+			t.Errorf("%s was claimed by more than one goroutine", id)
+		}
+	}
+	for id, n := range acquired {
+		if n > 1 {
+			t.Errorf("%s was Acquired by more than one goroutine", id)
+		}
+	}
+	// the Joins under the shared lease count as well as the Acquires
+	if held := l.Held(); len(held) != 2*workers || l.Status().Held != 2*workers {
+		t.Errorf("%d ids should be held; not: %v %v", 2*workers, held, l.Status())
+	}
+
+	var rwg sync.WaitGroup
+	for _, release := range releases {
+		rwg.Add(2)
+		go func(release func()) {
+			defer rwg.Done()
+			release()
+		}(release)
+		go func(release func()) {
+			defer rwg.Done()
+			release()
+		}(release)
+	}
+	released := make(chan bool, 2*len(members))
+	for _, m := range members {
+		rwg.Add(2)
+		for j := 0; j < 2; j++ {
+			go func(m *Member) {
+				defer rwg.Done()
+				ok, err := l.Release(ctx, m)
+				if err != nil {
+					t.Errorf("Release err: %v", err)
+				}
+				released <- ok
+			}(m)
+		}
+	}
+	rwg.Wait()
+	close(released)
+	n := 0
+	for ok := range released {
+		if ok {
+			n++
+		}
+	}
+	if n != len(members) {
+		t.Errorf("each Joined id should be released once; released %d of %d", n, len(members))
+	}
+	if got, err := client.Get(ctx, "/concurrent-locker/", clientv3.WithPrefix(), clientv3.WithCountOnly()); err != nil || got.Count != 0 {
+		t.Errorf("every id should be freed: %v %v", got, err)
+	}
+	if held := l.Held(); len(held) != 0 || l.Status().Held != 0 {
+		t.Errorf("no ids should be held once released; not: %v %v", held, l.Status())
+	}
+}
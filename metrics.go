@@ -0,0 +1,118 @@
+package stonecutters
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Metrics receives measurements of the claim calls, eg to feed histograms.
+// Implementations must be cheap and safe for concurrent use. Each
+// measurement is reported raw, for the implementation to aggregate;
+// LatencyHistogram is one which does.
+type Metrics interface {
+	// ObserveClaimLatency is called with the wall-clock duration of each
+	// successful claim, including the lease grant and any retries.
+	ObserveClaimLatency(d time.Duration)
+}
+
+// WithMetrics reports measurements of the call to m. Unset by default, at
+// no cost.
+func WithMetrics(m Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// latencyBuckets are the upper bounds of the LatencyHistogram buckets,
+// doubling from 1ms to about 33s; slower claims fall in a last, unbounded
+// bucket.
+var latencyBuckets = func() []time.Duration {
+	bounds := make([]time.Duration, 0, 16)
+	for d := time.Millisecond; d <= 40*time.Second; d *= 2 {
+		bounds = append(bounds, d)
+	}
+	return bounds
+}()
+
+// LatencyHistogram is a Metrics counting claim latencies into buckets
+// doubling from 1ms, for quantiles such as the p99 without keeping every
+// measurement. Quantiles are only as fine as the buckets: each is reported
+// as the upper bound of the bucket it falls in. The zero value is ready to
+// use.
+type LatencyHistogram struct {
+	mu     sync.Mutex
+	counts [17]int64 // per bucket of latencyBuckets, then over the last
+	total  int64
+}
+
+func (h *LatencyHistogram) ObserveClaimLatency(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	h.mu.Lock()
+	h.counts[i]++
+	h.total++
+	h.mu.Unlock()
+}
+
+// Count returns the number of latencies observed.
+func (h *LatencyHistogram) Count() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.total
+}
+
+// Quantile returns the latency below which the fraction q of those
+// observed fall, eg 0.99 for the p99, as the upper bound of its bucket.
+// Zero before any are observed; latencies beyond the last bucket report
+// its bound.
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range h.counts[:len(latencyBuckets)] {
+		if seen += n; seen >= rank {
+			return latencyBuckets[i]
+		}
+	}
+	return latencyBuckets[len(latencyBuckets)-1]
+}
+
+// P99 returns the 99th percentile claim latency, see Quantile.
+func (h *LatencyHistogram) P99() time.Duration {
+	return h.Quantile(0.99)
+}
+
+// withoutMetrics stops an inner call reporting the measurements its caller
+// reports.
+func withoutMetrics() Option {
+	return func(o *options) {
+		o.metrics = nil
+	}
+}
+
+// observeClaim reports a successful claim begun at start, returning its
+// latency.
+func (o *options) observeClaim(start time.Time) time.Duration {
+	d := o.clock.Now().Sub(start)
+	if o.metrics != nil {
+		o.metrics.ObserveClaimLatency(d)
+	}
+	return d
+}
+
+// Status is a snapshot of the claims of a Session or Locker.
+type Status struct {
+	Held             int
+	Unconfirmed      int           // fallback ids in use with no claim in etcd, see WithFallbackID
+	LastClaimLatency time.Duration // of the latest successful claim, zero before one
+}
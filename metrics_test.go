@@ -0,0 +1,93 @@
+package stonecutters
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedLatencies struct {
+	mu sync.Mutex
+	ds []time.Duration
+}
+
+func (r *recordedLatencies) ObserveClaimLatency(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ds = append(r.ds, d)
+}
+
+func (r *recordedLatencies) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.ds)
+}
+
+func TestClaimLatency(t *testing.T) {
+	ids := PrefixedNumerics("/latency/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := &recordedLatencies{}
+	s := NewSession(client)
+	defer s.Close()
+	if _, err := s.Claim(ctx, "hihi", ids, 10, WithMetrics(m)); err != nil {
+		t.Fatalf("Claim err: %v", err)
+	}
+	if m.count() != 1 {
+		t.Errorf("one claim should be observed once; observed: %d", m.count())
+	}
+	if st := s.Status(); st.Held != 1 || st.LastClaimLatency <= 0 {
+		t.Errorf("session status unexpected: %#v", st)
+	}
+
+	l := NewLocker(client, ids, WithMetrics(m))
+	_, release, err := l.Acquire(ctx, "hihi")
+	if err != nil {
+		t.Fatalf("Acquire err: %v", err)
+	}
+	if st := l.Status(); st.Held != 1 || st.LastClaimLatency <= 0 {
+		t.Errorf("locker status unexpected: %#v", st)
+	}
+	release()
+	if st := l.Status(); st.Held != 0 {
+		t.Errorf("released id should not be held: %#v", st)
+	}
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	if _, err := JoinRetry(client, ctx, lease.ID, "hihi", ids, RetryOptions{Attempts: 2}, WithMetrics(m)); err != nil {
+		t.Fatalf("JoinRetry err: %v", err)
+	}
+	if m.count() != 3 {
+		t.Errorf("every claim should be observed once; observed: %d", m.count())
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	var h LatencyHistogram
+	if h.P99() != 0 {
+		t.Errorf("an empty histogram should report zero: %v", h.P99())
+	}
+	for i := 0; i < 98; i++ {
+		h.ObserveClaimLatency(3 * time.Millisecond)
+	}
+	h.ObserveClaimLatency(100 * time.Millisecond)
+	h.ObserveClaimLatency(time.Minute)
+	if h.Count() != 100 {
+		t.Errorf("100 latencies should be counted; not: %d", h.Count())
+	}
+	if q := h.Quantile(0.5); q != 4*time.Millisecond {
+		t.Errorf("the median should be in the 4ms bucket; not: %v", q)
+	}
+	if q := h.P99(); q != 128*time.Millisecond {
+		t.Errorf("the p99 should be in the 128ms bucket; not: %v", q)
+	}
+	if q := h.Quantile(1); q != latencyBuckets[len(latencyBuckets)-1] {
+		t.Errorf("latencies past the last bucket should report its bound; not: %v", q)
+	}
+}
@@ -2,11 +2,16 @@ package stonecutters
 
 import (
 	"fmt"
+	"strings"
 
 	"golang.org/x/text/transform"
 	"golang.org/x/text/unicode/norm"
 )
 
+// maxIdSize is etcd's default --max-request-bytes, which bounds a claim's
+// key and value together.
+var maxIdSize = 3 * 512 * 1024
+
 var NAMountains = []string{
 	"Denali",
 	"MtLogan",
@@ -144,3 +149,46 @@ func PrefixedNumerics(prefix string, max int) []string {
 	}
 	return ids
 }
+
+// RangePool returns the ids prefix followed by each integer from 'from' to
+// 'to' inclusive, as PrefixedRange yields them.
+func RangePool(prefix string, from, to int) []string {
+	ids := make([]string, 0)
+	for i := from; i <= to; i++ {
+		ids = append(ids, fmt.Sprintf("%s%d", prefix, i))
+	}
+	return ids
+}
+
+// ValidatePool checks an id list before any claims are made: every id must
+// be non-empty, fit in an etcd request and appear only once. Returns a
+// MultiError listing every problem found rather than just the first.
+func ValidatePool(ids []string) error {
+	return validatePool(ids, "")
+}
+
+// validatePool checks ids as ValidatePool does, additionally rejecting ids
+// containing a non-empty separator.
+func validatePool(ids []string, sep string) error {
+	var errs MultiError
+	seen := make(map[string]int, len(ids))
+	for i, id := range ids {
+		switch {
+		case id == "":
+			errs = append(errs, fmt.Errorf("%w at %d: empty", InvalidIdError, i))
+		case len(id) > maxIdSize:
+			errs = append(errs, fmt.Errorf("%w at %d: %d bytes exceeds %d", InvalidIdError, i, len(id), maxIdSize))
+		case sep != "" && strings.Contains(id, sep):
+			errs = append(errs, fmt.Errorf("%w %q at %d: contains the separator %q", InvalidIdError, id, i, sep))
+		}
+		if first, dup := seen[id]; dup {
+			errs = append(errs, fmt.Errorf("%w %q at %d: duplicate of %d", InvalidIdError, id, i, first))
+		} else {
+			seen[id] = i
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
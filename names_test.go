@@ -1,6 +1,10 @@
 package stonecutters
 
-import "testing"
+import (
+	"errors"
+	"strings"
+	"testing"
+)
 
 func TestOrderedList(t *testing.T) {
 	if NAMountains[0] != "Denali" {
@@ -43,3 +47,29 @@ func TestNumericGen(t *testing.T) {
 	}
 
 }
+
+func TestValidatePool(t *testing.T) {
+	if err := ValidatePool(PrefixedNumerics("/metrics/testapp", 10)); err != nil {
+		t.Errorf("generated pool should be valid: %v", err)
+	}
+	if err := ValidatePool(NAMountains); err == nil {
+		t.Errorf("NAMountains lists WheelerPeak twice and should be flagged")
+	}
+
+	err := ValidatePool([]string{"a", "", "b", "a", strings.Repeat("x", maxIdSize+1)})
+	var errs MultiError
+	if !errors.As(err, &errs) {
+		t.Fatalf("err[%v] should be a MultiError", err)
+	}
+	if len(errs) != 3 {
+		t.Errorf("empty, duplicate and oversized ids should all be reported: %v", err)
+	}
+	if !errors.Is(err, InvalidIdError) {
+		t.Errorf("err[%v] should be InvalidIdError", err)
+	}
+
+	ns := Namespace{Name: "metrics"}
+	if err := ns.ValidatePool([]string{"ok", "not/ok"}); err == nil {
+		t.Errorf("ids containing the namespace separator should be flagged")
+	}
+}
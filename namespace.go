@@ -0,0 +1,95 @@
+package stonecutters
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var (
+	defaultSeparator = "/"
+	InvalidIdError   = errors.New("lock: invalid identifier")
+)
+
+// Namespace scopes ids under a common key prefix, 'Name' + 'Separator' +
+// id, so a prefix read over the namespace sees only its own ids.
+//
+// An id containing the separator would make keys ambiguous to a prefix
+// read (eg id "b/c" in namespace "a" reads the same as id "c" in namespace
+// "a/b"), so such ids are rejected unless Escape is set.
+type Namespace struct {
+	Name      string
+	Separator string // defaults to "/"
+	// Escape percent-encodes the separator (and '%') within ids rather
+	// than rejecting them.
+	Escape bool
+}
+
+func (n Namespace) separator() string {
+	if n.Separator == "" {
+		return defaultSeparator
+	}
+	return n.Separator
+}
+
+// Prefix returns the key prefix shared by every id in the namespace.
+func (n Namespace) Prefix() string {
+	return n.Name + n.separator()
+}
+
+// Key returns the namespaced key for id.
+func (n Namespace) Key(id string) (string, error) {
+	sep := n.separator()
+	if id == "" {
+		return "", fmt.Errorf("%w: empty", InvalidIdError)
+	}
+	if n.Escape {
+		id = strings.NewReplacer("%", "%25", sep, percentEncode(sep)).Replace(id)
+	} else if strings.Contains(id, sep) {
+		return "", fmt.Errorf("%w %q: contains the separator %q", InvalidIdError, id, sep)
+	}
+	return n.Prefix() + id, nil
+}
+
+// Keys returns the namespaced key for each id, in order.
+func (n Namespace) Keys(ids []string) ([]string, error) {
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		k, err := n.Key(id)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// ID returns the id a namespaced key was built from.
+func (n Namespace) ID(key string) (string, error) {
+	if !strings.HasPrefix(key, n.Prefix()) {
+		return "", fmt.Errorf("%w: key %q is outside namespace %q", InvalidIdError, key, n.Name)
+	}
+	id := strings.TrimPrefix(key, n.Prefix())
+	if !n.Escape {
+		return id, nil
+	}
+	return url.PathUnescape(id)
+}
+
+func percentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		fmt.Fprintf(&b, "%%%02X", s[i])
+	}
+	return b.String()
+}
+
+// ValidatePool checks ids as the package ValidatePool does and, unless the
+// namespace escapes them, that none contain the separator.
+func (n Namespace) ValidatePool(ids []string) error {
+	if n.Escape {
+		return validatePool(ids, "")
+	}
+	return validatePool(ids, n.separator())
+}
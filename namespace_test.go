@@ -0,0 +1,41 @@
+package stonecutters
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNamespaceKeys(t *testing.T) {
+	ns := Namespace{Name: "/metrics"}
+	k, err := ns.Key("Denali")
+	if err != nil || k != "/metrics/Denali" {
+		t.Errorf("key should be /metrics/Denali: %q %v", k, err)
+	}
+	for _, id := range []string{"", "foo/bar"} {
+		if _, err := ns.Key(id); !errors.Is(err, InvalidIdError) {
+			t.Errorf("id %q err[%v] should be InvalidIdError", id, err)
+		}
+	}
+	if _, err := ns.Keys([]string{"ok", "not/ok"}); err == nil {
+		t.Errorf("keys with an embedded separator should be rejected")
+	}
+
+	colon := Namespace{Name: "metrics", Separator: ":", Escape: true}
+	ids := []string{"host:1", "100%", "plain"}
+	keys, err := colon.Keys(ids)
+	if err != nil {
+		t.Fatalf("escaped keys err: %v", err)
+	}
+	if keys[0] != "metrics:host%3A1" || keys[1] != "metrics:100%25" {
+		t.Errorf("escaped keys unexpected: %v", keys)
+	}
+	for i, k := range keys {
+		id, err := colon.ID(k)
+		if err != nil || id != ids[i] {
+			t.Errorf("key %q should map back to %q: %q %v", k, ids[i], id, err)
+		}
+	}
+	if _, err := colon.ID("other:plain"); err == nil {
+		t.Errorf("key outside the namespace should not map to an id")
+	}
+}
@@ -0,0 +1,371 @@
+package stonecutters
+
+import (
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// Option configures optional behaviour of the claim and read calls. Each
+// call documents the options it honours; others are ignored.
+type Option func(*options)
+
+type options struct {
+	filter      func(id string) bool
+	affinity    string
+	adoptValues []string
+	token       string
+	idemKey     string
+	errPolicy   ErrorPolicy
+	conflict    ConflictPolicy
+	report      func(Attempt)
+
+	minTTLRequested int64
+	minTTLFraction  float64
+
+	leaseTTL int64
+	ttlFor   func(id string) int64
+
+	rate    float64
+	burst   int
+	limiter *tokenBucket // set by a Locker
+
+	metrics      Metrics
+	serializable bool
+	pinnedVerify bool
+
+	sink       EventSink
+	sinkBuffer int
+	debounce   time.Duration
+
+	ttlSample time.Duration
+
+	conditions []clientv3.Cmp
+
+	clock clock
+
+	order func(ids []string) []string
+	stats *ContentionStats
+
+	deletion DeletionPolicy
+
+	audit AuditSink
+
+	keyTimeout   time.Duration
+	readParallel int
+
+	tombstone    bool
+	tombstoneTTL int64
+
+	fallbackID    string
+	fallbackAfter time.Duration
+
+	keyFn func(id string) string
+	idFn  func(key string) (string, bool)
+	idOf  map[string]string // the keys of a Join to their ids
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{clock: realClock{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithFilter makes Join skip any id the predicate rejects. The predicate is
+// consulted just before each id is tried, so ids can be drained or put into
+// maintenance without rebuilding the id list.
+func WithFilter(f func(id string) bool) Option {
+	return func(o *options) {
+		o.filter = f
+	}
+}
+
+// WithAffinity makes Join first take over an id already claimed by an
+// owner with the same Identity Stable identity, eg a restarted Kubernetes
+// pod whose hostname changed but whose StatefulSet ordinal did not. The
+// previous owner's claim is rebound to the new lease and name. Without a
+// match Join claims as normal.
+func WithAffinity(stable string) Option {
+	return func(o *options) {
+		o.affinity = stable
+	}
+}
+
+// WithAdoptValues makes Join first take over an id already claimed with any
+// of the given values, rebinding it to the new lease and name.
+//
+// This is a transitional feature for migrating the format of owner values:
+// passing a worker's old format value lets a restarted worker re-adopt its
+// claim while others still run the old format, without restarting them all
+// at once. Drop it once every worker writes the new format.
+func WithAdoptValues(values ...string) Option {
+	return func(o *options) {
+		o.adoptValues = append(o.adoptValues, values...)
+	}
+}
+
+// WithIdempotencyToken makes Join first take over an id already claimed
+// with an Identity carrying the same Token, rebinding it to the new lease
+// and name. Use one token per logical claim, kept across retries and
+// process restarts, and record it in the Identity; JoinAs does so.
+//
+// When a claim's txn commits but its response is lost, eg to a network
+// error, the caller cannot tell whether it owns the id. Retrying with the
+// same token recognises the earlier claim as its own rather than someone
+// else's and returns it, so at most one id is held per token: exactly once
+// as long as the earlier claim's lease is alive, else a fresh claim.
+func WithIdempotencyToken(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
+
+// adoptable returns true if Join may take over an id claimed with value.
+func (o *options) adoptable(value string) bool {
+	for _, v := range o.adoptValues {
+		if v == value {
+			return true
+		}
+	}
+	if o.affinity == "" && o.token == "" {
+		return false
+	}
+	ident, err := DecodeIdentity(value)
+	if err != nil {
+		return false
+	}
+	return (o.affinity != "" && ident.Stable == o.affinity) ||
+		(o.token != "" && ident.Token == o.token)
+}
+
+// WithMinTTL makes Join confirm etcd granted the lease at least 'fraction'
+// of the 'requested' TTL (seconds) before claiming, failing with
+// ErrTTLTooShort rather than operating on a lease shortened under cluster
+// stress. Session.Claim checks its own Grant and fills in requested when it
+// is zero.
+func WithMinTTL(requested int64, fraction float64) Option {
+	return func(o *options) {
+		o.minTTLRequested = requested
+		o.minTTLFraction = fraction
+	}
+}
+
+// ErrorPolicy decides what Join returns when claiming an id fails with an
+// error other than contention, eg a timeout.
+type ErrorPolicy int
+
+const (
+	// ErrorsExhaust skips to the next id, and returns GetIdFailure if none
+	// could be claimed, as though the failed ids were contended.
+	ErrorsExhaust ErrorPolicy = iota
+	// ErrorsReport skips to the next id, but returns the first error rather
+	// than GetIdFailure if none could be claimed.
+	ErrorsReport
+	// ErrorsFailFast returns the first error without trying further ids.
+	ErrorsFailFast
+)
+
+// WithErrorPolicy sets how Join treats errors other than contention.
+// Defaults to ErrorsExhaust. Auth errors, anomalies and a closed context
+// always end Join at once regardless.
+func WithErrorPolicy(p ErrorPolicy) Option {
+	return func(o *options) {
+		o.errPolicy = p
+	}
+}
+
+// ConflictPolicy decides what Join does when its claim Txn succeeds but
+// reading the key back shows another value, ie VerificationError.
+type ConflictPolicy int
+
+const (
+	// ConflictNextID releases the key if it is still ours and moves on to
+	// the next id.
+	ConflictNextID ConflictPolicy = iota
+	// ConflictBackoffNextID is ConflictNextID after a short random delay,
+	// to let whatever is racing on the pool settle.
+	ConflictBackoffNextID
+	// ConflictRetrySameID deletes the key if it is bound to our lease,
+	// whatever its value, and claims it once more.
+	ConflictRetrySameID
+	// ConflictSurface returns VerificationError, leaving the key as it is.
+	ConflictSurface
+)
+
+// WithConflictPolicy sets how Join resolves a claim which reads back with
+// another value. Defaults to ConflictNextID, which never leaves a claim of
+// ours in an ambiguous state.
+func WithConflictPolicy(p ConflictPolicy) Option {
+	return func(o *options) {
+		o.conflict = p
+	}
+}
+
+// Outcome is the result of Join trying a single id.
+type Outcome int
+
+const (
+	AttemptClaimed  Outcome = iota // the id was claimed, or adopted
+	AttemptConflict                // the id is held by another owner
+	AttemptError                   // the claim failed, see Attempt.Err
+	AttemptFiltered                // the id was skipped by WithFilter
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case AttemptClaimed:
+		return "claimed"
+	case AttemptConflict:
+		return "conflict"
+	case AttemptError:
+		return "error"
+	case AttemptFiltered:
+		return "filtered"
+	}
+	return "unknown"
+}
+
+// Attempt reports the outcome of Join trying an id.
+type Attempt struct {
+	ID      string
+	Outcome Outcome
+	Err     error // set for AttemptError
+}
+
+// WithAttemptReport makes Join call f with the outcome of each id it tries,
+// in order, to diagnose why a process never gets an id. f is called on
+// Join's goroutine and should not block.
+func WithAttemptReport(f func(Attempt)) Option {
+	return func(o *options) {
+		o.report = f
+	}
+}
+
+func (o *options) attempt(id string, outcome Outcome, err error) {
+	if o.idOf != nil {
+		id = o.idOf[id]
+	}
+	if o.report != nil {
+		o.report(Attempt{ID: id, Outcome: outcome, Err: err})
+	}
+	if o.stats != nil {
+		o.stats.Record(Attempt{ID: id, Outcome: outcome, Err: err})
+	}
+}
+
+// WithOrdering makes Join try the ids in the order f returns rather than
+// the order given, eg to spread claimants over the pool. f must return
+// ids from the list; it may drop some but should not add others. The list
+// passed in must not be modified.
+func WithOrdering(f func(ids []string) []string) Option {
+	return func(o *options) {
+		o.order = f
+	}
+}
+
+// WithSerializable makes reads serializable: served by whichever member the
+// client is connected to from its local copy, without a round of consensus.
+// They are faster and spare the leader, for dashboards polling the roster,
+// but may be stale, missing recent claims and releases or, from a member
+// partitioned from the cluster, arbitrarily old ones. Never decide
+// ownership from a serializable read. Reads are linearizable by default.
+func WithSerializable() Option {
+	return func(o *options) {
+		o.serializable = true
+	}
+}
+
+// WithPinnedVerify makes Join verify a claim by reading it back at the
+// revision of the claim's Txn, and requiring the key was written at that
+// revision, rather than reading the latest value. The read back is the
+// claim's own write and nothing newer, so VerificationError means the Txn
+// reported success without the key holding its write. Verification reads
+// are always linearizable, WithSerializable or not.
+func WithPinnedVerify() Option {
+	return func(o *options) {
+		o.pinnedVerify = true
+	}
+}
+
+// verifyClaim reads back a claim of key with value, written by a Txn at
+// rev, as the options say.
+func (o *options) verifyClaim(c *clientv3.Client, key, value string, rev int64) error {
+	if o.pinnedVerify {
+		return verifyWriteAt(c, key, value, rev)
+	}
+	return verifyKvPairAt(c, key, value, 0)
+}
+
+// WithConditions adds compares, typically on keys outside the pool, to the
+// Txn of each claim, eg that an epoch key still has the value read when the
+// work was assigned. They are evaluated atomically with the check that the
+// id is free: an id is only claimed if every condition holds at the
+// revision it is claimed at, and nothing is written otherwise. If an id is
+// free but a condition fails, the claim stops with ConditionFailure rather
+// than trying the remaining ids.
+func WithConditions(cmps ...clientv3.Cmp) Option {
+	return func(o *options) {
+		o.conditions = append(o.conditions, cmps...)
+	}
+}
+
+// WithKeyTransform makes ids claimed under the etcd key f returns rather
+// than as is, eg "shard-3/node-42" for "node-42", to control where claims
+// are placed in the keyspace. inverse must undo f, returning false for keys
+// f never returns, so that prefix reads such as ReadRoster can map keys back
+// to ids. Members returned record the etcd key as Key and the id as ID.
+// Filters, orderings and attempt reports still see the ids. Keys are what
+// verify and release operate on: pass a Member's Key to ReleaseIf.
+func WithKeyTransform(f func(id string) string, inverse func(key string) (id string, ok bool)) Option {
+	return func(o *options) {
+		o.keyFn, o.idFn = f, inverse
+	}
+}
+
+// transformKeys maps the ids to their keys, recording the id of each key
+// so that filters and reports keep seeing ids.
+func (o *options) transformKeys(ids []string) []string {
+	keys := make([]string, len(ids))
+	o.idOf = make(map[string]string, len(ids))
+	for i, id := range ids {
+		keys[i] = o.keyFn(id)
+		o.idOf[keys[i]] = id
+	}
+	if filter := o.filter; filter != nil {
+		o.filter = func(key string) bool { return filter(o.idOf[key]) }
+	}
+	return keys
+}
+
+// WithTTLSampleInterval sets how often a Session samples the TTL left on
+// its claims for TTLUpdates. Defaults to a second.
+func WithTTLSampleInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.ttlSample = d
+	}
+}
+
+// readOpts returns the OpOptions of a read honouring the options.
+func (o *options) readOpts(opts ...clientv3.OpOption) []clientv3.OpOption {
+	if o.serializable {
+		opts = append(opts, clientv3.WithSerializable())
+	}
+	return opts
+}
+
+// WithTTLFunc gives each id the lease TTL, in seconds, returned by f, so
+// that eg reserved ids outlast blips which free burst ids. Zero falls back
+// to the call's TTL. The ids are tried in order, that of WithOrdering or
+// WithLeastContended if given, each under a lease of its TTL, leases being
+// shared between ids of the same TTL until one is claimed.
+//
+// Only calls which grant their own leases, such as Session.Claim and
+// Locker.Acquire, honour it; a lease given by the caller, as to Join, has a
+// single TTL whichever id it claims.
+func WithTTLFunc(f func(id string) int64) Option {
+	return func(o *options) {
+		o.ttlFor = f
+	}
+}
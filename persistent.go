@@ -0,0 +1,56 @@
+package stonecutters
+
+import (
+	"context"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// ClaimPersistent claims one of the ids as JoinAs does, but writes the key
+// without a lease: the claim survives restarts of its owner and outages of
+// any length, and ends only when released with ReleasePersistent. For
+// long-term assignments which mustn't flap, the opposite tradeoff from a
+// leased claim.
+//
+// NOTHING EVER FREES A PERSISTENT CLAIM ON ITS OWN. An owner which crashes
+// for good, or forgets its Member, leaves its key claimed until reaped by
+// hand. Persistent claims are reported by OrphanedKeys and PersistentClaims
+// like any leaseless key; Reap frees those of owners known to be dead, by
+// the Identity name, or started longer ago than its MaxAge, by the Identity
+// Since, which is why the owner is recorded as an Identity.
+//
+// Honours the options of Join, save WithMinTTL, there being no lease to
+// check, and WithIdempotencyKey, whose record would never expire; both are
+// ignored.
+func ClaimPersistent(c *clientv3.Client, ctx context.Context, ident *Identity, ids []string,
+	opts ...Option) (*Member, error) {
+	opts = append(opts[:len(opts):len(opts)], WithMinTTL(0, 0), WithIdempotencyKey(""))
+	return JoinAs(c, ctx, clientv3.NoLease, ident, ids, opts...)
+}
+
+// ReleasePersistent frees the persistent claim m, only if its key still
+// holds m's value and no lease, as ReleaseIf does. Returns false if it no
+// longer matched, eg because it was reaped and claimed again.
+//
+// Honours WithTombstone and WithAuditSink.
+func ReleasePersistent(c *clientv3.Client, ctx context.Context, m *Member, opts ...Option) (bool, error) {
+	return ReleaseIf(c, ctx, clientv3.NoLease, m.Key, m.Value, opts...)
+}
+
+// PersistentClaims returns the members under prefix held without a lease,
+// sorted by key, for an admin deciding which to Reap.
+//
+// Honours WithSerializable.
+func PersistentClaims(c *clientv3.Client, ctx context.Context, prefix string, opts ...Option) ([]*Member, error) {
+	r, err := ReadRoster(c, ctx, prefix, opts...)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]*Member, 0)
+	for i := range r.Members {
+		if r.Members[i].LeaseID == clientv3.NoLease {
+			members = append(members, &r.Members[i])
+		}
+	}
+	return members, nil
+}
@@ -0,0 +1,78 @@
+package stonecutters
+
+import (
+	"context"
+	"testing"
+)
+
+func TestClaimPersistent(t *testing.T) {
+	prefix := "/persistent/"
+	ids := PrefixedNumerics(prefix, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m, err := ClaimPersistent(client, ctx, NewIdentity("hihi"), ids)
+	if err != nil {
+		t.Fatalf("ClaimPersistent err: %v", err)
+	}
+	got, err := client.Get(ctx, m.Key)
+	if err != nil || len(got.Kvs) != 1 || got.Kvs[0].Lease != 0 {
+		t.Fatalf("%s should be claimed without a lease: %v %v", m.Key, got, err)
+	}
+	crashed, err := ClaimPersistent(client, ctx, NewIdentity("hihi-crashed"), ids)
+	if err != nil {
+		t.Fatalf("ClaimPersistent err: %v", err)
+	}
+	if crashed.Key == m.Key {
+		t.Fatalf("a persistent claim should block its id: %s", crashed.Key)
+	}
+
+	held, err := PersistentClaims(client, ctx, prefix)
+	if err != nil || len(held) != 2 {
+		t.Fatalf("both persistent claims should be listed: %v %v", held, err)
+	}
+	orphans, err := OrphanedKeys(client, ctx, prefix)
+	if err != nil || len(orphans) != 2 {
+		t.Errorf("persistent claims should be reported as leaseless: %v %v", orphans, err)
+	}
+
+	// only the owner's own value releases it
+	other := *m
+	other.Value = "hihi"
+	if ok, err := ReleasePersistent(client, ctx, &other); err != nil || ok {
+		t.Errorf("a release by another owner should fail: %v %v", ok, err)
+	}
+	if ok, err := ReleasePersistent(client, ctx, m); err != nil || !ok {
+		t.Errorf("ReleasePersistent should release %s: %v %v", m.Key, ok, err)
+	}
+
+	// the crashed owner's claim is left for the reaper
+	reaped, err := Reap(client, ctx, ids, ReapOptions{DeadOwners: []string{"hihi-crashed"}})
+	if err != nil || len(reaped) != 1 || reaped[0].Key != crashed.Key {
+		t.Fatalf("Reap should free %s: %v %v", crashed.Key, reaped, err)
+	}
+	if held, err := PersistentClaims(client, ctx, prefix); err != nil || len(held) != 0 {
+		t.Errorf("no persistent claims should remain: %v %v", held, err)
+	}
+}
+
+func TestClaimPersistentIgnoresLeaseOptions(t *testing.T) {
+	ids := PrefixedNumerics("/persistent-opts/", 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m, err := ClaimPersistent(client, ctx, NewIdentity("hihi"), ids, WithMinTTL(10, 0.5))
+	if err != nil {
+		t.Fatalf("WithMinTTL should be ignored: %v", err)
+	}
+	defer ReleasePersistent(client, ctx, m)
+
+	m, err = ClaimPersistent(client, ctx, NewIdentity("hihi"), ids, WithIdempotencyKey("persistent-1"))
+	if err != nil {
+		t.Fatalf("ClaimPersistent err: %v", err)
+	}
+	defer ReleasePersistent(client, ctx, m)
+	if got, err := client.Get(ctx, IdempotencyRecordKey("persistent-1")); err != nil || len(got.Kvs) != 0 {
+		t.Errorf("WithIdempotencyKey should leave no record: %v %v", got, err)
+	}
+}
@@ -0,0 +1,88 @@
+package stonecutters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+var (
+	poolConfigPrefix      = "config/"
+	ConfigConflictFailure = errors.New("lock: pool config was changed since it was loaded")
+)
+
+// PoolConfig is pool wide policy stored in etcd, so every node claiming
+// from the pool agrees on it without a redeploy.
+type PoolConfig struct {
+	MaxSize    int   `json:"max_size,omitempty"`    // claim from only the first MaxSize ids
+	DefaultTTL int64 `json:"default_ttl,omitempty"` // seconds, for leases a Locker grants
+
+	// AutoGrow starts the pool at InitialSize ids (default 1), doubling
+	// it whenever every id is taken, up to MaxSize; see JoinGrowing.
+	AutoGrow    bool `json:"auto_grow,omitempty"`
+	InitialSize int  `json:"initial_size,omitempty"`
+
+	// Revision is the etcd revision the config was last written at, zero
+	// if it has never been saved. SavePoolConfig only writes over the
+	// revision it was loaded at.
+	Revision int64 `json:"-"`
+}
+
+// PoolConfigKey returns the key the config of the pool under prefix is
+// stored at. It is kept out of the pool's own range so reads of the pool's
+// members don't see it.
+func PoolConfigKey(prefix string) string {
+	return poolConfigPrefix + prefix
+}
+
+// LoadPoolConfig reads the config of the pool under prefix. A pool without
+// a saved config has the zero PoolConfig.
+func LoadPoolConfig(c *clientv3.Client, ctx context.Context, prefix string) (*PoolConfig, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	got, err := c.Get(ctx, PoolConfigKey(prefix))
+	if err != nil {
+		return nil, authError(err)
+	}
+	cfg := &PoolConfig{}
+	if len(got.Kvs) == 0 {
+		return cfg, nil
+	}
+	if err := json.Unmarshal(got.Kvs[0].Value, cfg); err != nil {
+		return nil, fmt.Errorf("lock: invalid pool config %q: %v", got.Kvs[0].Value, err)
+	}
+	cfg.Revision = got.Kvs[0].ModRevision
+	return cfg, nil
+}
+
+// SavePoolConfig writes the config of the pool under prefix, only if it is
+// unchanged since cfg was loaded; otherwise nothing is written and
+// ConfigConflictFailure is returned, so concurrent writers don't clobber
+// each other. Reload and reapply the change to retry. On success
+// cfg.Revision is updated to the write.
+func SavePoolConfig(c *clientv3.Client, ctx context.Context, prefix string, cfg *PoolConfig) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	key := PoolConfigKey(prefix)
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", cfg.Revision)).
+		Then(clientv3.OpPut(key, string(b))).
+		Commit()
+	if err != nil {
+		return authError(err)
+	}
+	if !resp.Succeeded {
+		return ConfigConflictFailure
+	}
+	cfg.Revision = resp.Header.Revision
+	return nil
+}
@@ -0,0 +1,67 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestPoolConfig(t *testing.T) {
+	prefix := "/poolconfig/"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg, err := LoadPoolConfig(client, ctx, prefix)
+	if err != nil {
+		t.Fatalf("LoadPoolConfig err: %v", err)
+	}
+	if cfg.Revision != 0 || cfg.MaxSize != 0 {
+		t.Errorf("unsaved config should be zero: %#v", cfg)
+	}
+
+	cfg.MaxSize = 2
+	cfg.DefaultTTL = 7
+	if err := SavePoolConfig(client, ctx, prefix, cfg); err != nil {
+		t.Fatalf("SavePoolConfig err: %v", err)
+	}
+	stale := &PoolConfig{MaxSize: 5}
+	if err := SavePoolConfig(client, ctx, prefix, stale); err != ConfigConflictFailure {
+		t.Errorf("err[%v] should be ConfigConflictFailure", err)
+	}
+
+	got, err := LoadPoolConfig(client, ctx, prefix)
+	if err != nil {
+		t.Fatalf("LoadPoolConfig err: %v", err)
+	}
+	if got.MaxSize != 2 || got.DefaultTTL != 7 || got.Revision != cfg.Revision {
+		t.Errorf("loaded config %#v should match saved %#v", got, cfg)
+	}
+
+	l := NewLocker(client, PrefixedNumerics(prefix, 4))
+	if _, err := l.LoadConfig(ctx, prefix); err != nil {
+		t.Fatalf("LoadConfig err: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		id, release, err := l.Acquire(ctx, "hihi")
+		if err != nil {
+			t.Fatalf("Acquire err: %v", err)
+		}
+		defer release()
+		got, err := client.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ttl, err := client.TimeToLive(ctx, clientv3.LeaseID(got.Kvs[0].Lease))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl.GrantedTTL != 7 {
+			t.Errorf("lease TTL should come from the config; granted: %d", ttl.GrantedTTL)
+		}
+	}
+	if _, _, err := l.Acquire(ctx, "hihi"); !errors.Is(err, GetIdFailure) {
+		t.Errorf("err[%v] should be GetIdFailure past the config MaxSize", err)
+	}
+}
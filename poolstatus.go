@@ -0,0 +1,127 @@
+package stonecutters
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+)
+
+var poolSeedPrefix = "seed/"
+
+// PoolSeedKey returns the key id is registered under as part of its pool
+// by SeedPool. Seeds are kept out of the pool's own range, where a key
+// would mark the id claimed.
+func PoolSeedKey(id string) string {
+	return poolSeedPrefix + id
+}
+
+// SeedPool registers the ids as the full set of a pool, claimed or not, for
+// PoolStatus to report ids nobody holds. Seeding an id twice is harmless;
+// seeds persist until deleted, they don't take part in claims.
+func SeedPool(c *clientv3.Client, ctx context.Context, ids []string) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	for start := 0; start < len(ids); start += maxTxnOps {
+		end := start + maxTxnOps
+		if end > len(ids) {
+			end = len(ids)
+		}
+		ops := make([]clientv3.Op, 0, end-start)
+		for _, id := range ids[start:end] {
+			ops = append(ops, clientv3.OpPut(PoolSeedKey(id), ""))
+		}
+		if _, err := c.Txn(ctx).Then(ops...).Commit(); err != nil {
+			return authError(err)
+		}
+	}
+	return nil
+}
+
+// IDStatus is the state of one id of a pool.
+type IDStatus struct {
+	ID       string
+	Seeded   bool // registered with SeedPool
+	Held     bool
+	Revision int64 // the revision the pool was read at, the same for every id
+
+	// of the holder, when Held
+	Value          string
+	LeaseID        clientv3.LeaseID
+	Remaining      time.Duration // left on the lease; zero if gone or leaseless
+	CreateRevision int64         // the revision it was claimed at
+	Since          time.Time     // from the holder's Identity, zero for plain names
+}
+
+// PoolStatus returns every id under prefix, seeded or held, sorted by id:
+// seeded ids nobody holds are reported with Held false, and held ids
+// which were never seeded with Seeded false. Held ids carry their holder,
+// lease and when they were claimed. Seeds and claims are read together at
+// a single revision, so no claim is caught half way. Each lease is looked
+// up once however many ids share it.
+//
+// Honours WithSerializable.
+func PoolStatus(c *clientv3.Client, ctx context.Context, prefix string, opts ...Option) ([]IDStatus, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	readOpts := newOptions(opts).readOpts(clientv3.WithPrefix())
+	resp, err := c.Txn(ctx).Then(
+		clientv3.OpGet(PoolSeedKey(prefix), append(readOpts, clientv3.WithKeysOnly())...),
+		clientv3.OpGet(prefix, readOpts...),
+	).Commit()
+	if err != nil {
+		return nil, authError(err)
+	}
+	seeds := resp.Responses[0].GetResponseRange().Kvs
+	held := resp.Responses[1].GetResponseRange().Kvs
+	rev := resp.Header.Revision
+
+	status := make(map[string]*IDStatus, len(seeds)+len(held))
+	for _, kv := range seeds {
+		id := strings.TrimPrefix(string(kv.Key), poolSeedPrefix)
+		status[id] = &IDStatus{ID: id, Seeded: true, Revision: rev}
+	}
+	leases := make(map[clientv3.LeaseID]time.Duration)
+	for _, kv := range held {
+		id := string(kv.Key)
+		st, ok := status[id]
+		if !ok {
+			st = &IDStatus{ID: id, Revision: rev}
+			status[id] = st
+		}
+		st.Held = true
+		st.Value = string(kv.Value)
+		st.LeaseID = clientv3.LeaseID(kv.Lease)
+		st.CreateRevision = kv.CreateRevision
+		if ident, err := DecodeIdentity(st.Value); err == nil {
+			st.Since = ident.Since
+		}
+		if st.LeaseID == clientv3.NoLease {
+			continue
+		}
+		remaining, ok := leases[st.LeaseID]
+		if !ok {
+			ttl, err := c.TimeToLive(ctx, st.LeaseID)
+			if err != nil && err != rpctypes.ErrLeaseNotFound {
+				return nil, authError(err)
+			}
+			if err == nil && ttl.TTL > 0 {
+				remaining = time.Duration(ttl.TTL) * time.Second
+			}
+			leases[st.LeaseID] = remaining
+		}
+		st.Remaining = remaining
+	}
+
+	out := make([]IDStatus, 0, len(status))
+	for _, st := range status {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
@@ -0,0 +1,54 @@
+package stonecutters
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPoolStatus(t *testing.T) {
+	prefix := "/poolstatus/"
+	ids := PrefixedNumerics(prefix, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := SeedPool(client, ctx, ids[:2]); err != nil {
+		t.Fatalf("SeedPool err: %v", err)
+	}
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	if _, err := Join(client, ctx, lease.ID, "hihi", ids[1:2]); err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	// held without ever being seeded
+	last, err := JoinAs(client, ctx, lease.ID, NewIdentity("hihi-other"), ids[2:])
+	if err != nil {
+		t.Fatalf("JoinAs err: %v", err)
+	}
+
+	status, err := PoolStatus(client, ctx, prefix)
+	if err != nil {
+		t.Fatalf("PoolStatus err: %v", err)
+	}
+	if len(status) != 3 {
+		t.Fatalf("every seeded or held id should be reported: %+v", status)
+	}
+	if st := status[0]; st.ID != ids[0] || !st.Seeded || st.Held {
+		t.Errorf("%s should be seeded and free: %+v", ids[0], st)
+	}
+	if st := status[1]; st.ID != ids[1] || !st.Seeded || !st.Held || st.Value != "hihi" ||
+		st.LeaseID != lease.ID || st.Remaining <= 0 || st.Remaining > 10*time.Second {
+		t.Errorf("%s should be seeded and held under the lease: %+v", ids[1], st)
+	}
+	if st := status[2]; st.ID != ids[2] || st.Seeded || !st.Held || st.Since.IsZero() {
+		t.Errorf("%s should be held unseeded, since the Identity's start: %+v", ids[2], st)
+	}
+	for _, st := range status {
+		if st.Revision < last.CreateRevision || st.Revision != status[0].Revision {
+			t.Errorf("every id should be read at one revision after %d: %+v", last.CreateRevision, status)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package stonecutters
+
+import (
+	"context"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// ClaimQuorum attempts to claim every key under the lease and considers the
+// lock held once a strict majority of them are claimed, in the style of
+// Redlock. On success acquired lists the keys claimed and ok is true.
+//
+// Falling short of a majority releases every key claimed along the way, so
+// a failed attempt never leaves a partial hold behind; acquired is then
+// empty unless a release failed, in which case it lists the keys which may
+// still be held and err is the release error. Otherwise err is the first
+// error other than a contended key seen while claiming.
+func ClaimQuorum(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	name string, keys []string) (acquired []string, ok bool, err error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, false, err
+	}
+	acquired = make([]string, 0, len(keys))
+	for _, key := range keys {
+		_, perr := kvPutLease(c, ctx, leaseID, key, name)
+		if perr == nil {
+			acquired = append(acquired, key)
+		} else if perr != PutSucceededFailure && err == nil {
+			err = perr
+		}
+	}
+	if len(acquired) > len(keys)/2 {
+		return acquired, true, nil
+	}
+
+	held := make([]string, 0)
+	for _, key := range acquired {
+		if _, rerr := ReleaseIf(c, ctx, leaseID, key, name); rerr != nil {
+			held = append(held, key)
+			err = rerr
+		}
+	}
+	return held, false, err
+}
@@ -0,0 +1,66 @@
+package stonecutters
+
+import (
+	"context"
+	"testing"
+)
+
+func TestClaimQuorum(t *testing.T) {
+	keys := PrefixedNumerics("/quorum/", 5)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, first.ID)
+	second, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, second.ID)
+
+	// hold two of five ahead of time; three is still a majority
+	for _, k := range keys[:2] {
+		if _, err := kvPutLease(client, ctx, second.ID, k, "hihi-second"); err != nil {
+			t.Fatalf("error holding %s: %v", k, err)
+		}
+	}
+	acquired, ok, err := ClaimQuorum(client, ctx, first.ID, "hihi-first", keys)
+	if err != nil || !ok {
+		t.Fatalf("quorum should be reached: %v %v", ok, err)
+	}
+	if len(acquired) != 3 {
+		t.Errorf("acquired should be 3 keys; not: %v", acquired)
+	}
+
+	// the second claimant can reach at most two; it must let them go
+	if _, err := client.Revoke(ctx, first.ID); err != nil {
+		t.Fatalf("error revoking lease: %v", err)
+	}
+	third, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, third.ID)
+	for _, k := range keys[2:4] {
+		if _, err := kvPutLease(client, ctx, third.ID, k, "hihi-third"); err != nil {
+			t.Fatalf("error holding %s: %v", k, err)
+		}
+	}
+	acquired, ok, err = ClaimQuorum(client, ctx, second.ID, "hihi-second", keys[2:])
+	if err != nil || ok {
+		t.Errorf("quorum should not be reached: %v %v", ok, err)
+	}
+	if len(acquired) != 0 {
+		t.Errorf("failed quorum should hold nothing; holds: %v", acquired)
+	}
+	got, err := client.Get(ctx, keys[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Kvs) > 0 {
+		t.Errorf("%s should have been released after the failed quorum", keys[4])
+	}
+}
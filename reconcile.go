@@ -0,0 +1,145 @@
+package stonecutters
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// ActionKind is what Reconcile did to a key.
+type ActionKind int
+
+const (
+	ActionClaimed  ActionKind = iota // claimed a desired key which was free
+	ActionReleased                   // released a key which isn't desired
+	ActionTookOver                   // rebound a desired key held with another value
+	ActionConflict                   // left a desired key held with another value
+)
+
+func (k ActionKind) String() string {
+	switch k {
+	case ActionClaimed:
+		return "claimed"
+	case ActionReleased:
+		return "released"
+	case ActionTookOver:
+		return "took over"
+	case ActionConflict:
+		return "conflict"
+	}
+	return "unknown"
+}
+
+// Action is a change Reconcile made, or declined to make, to a key.
+type Action struct {
+	Kind  ActionKind
+	Key   string
+	Value string // the desired value, or the released value
+}
+
+// ReconcileOptions configures Reconcile.
+type ReconcileOptions struct {
+	// TakeOver rebinds desired keys held with another value to the desired
+	// value and the lease. By default they are left as they are and
+	// reported as an ActionConflict.
+	TakeOver bool
+}
+
+// Reconcile converges the keys under prefix on the desired map of key to
+// value: desired keys which are free are claimed under the lease, keys not
+// desired are released and keys already held with their desired value are
+// left alone, whichever lease holds them. Each change is made only if the
+// key is still as it was read, so a key changed meanwhile is left for the
+// next pass. Returns the actions taken, in key order, for a controller to
+// call on every resync.
+//
+// Errors don't stop the pass; err is a MultiError of those seen.
+func Reconcile(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID, prefix string,
+	desired map[string]string, ro ReconcileOptions) ([]Action, error) {
+	r, err := ReadRoster(c, ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	actual := make(map[string]*Member, len(r.Members))
+	keys := make([]string, 0, len(r.Members)+len(desired))
+	for i := range r.Members {
+		m := &r.Members[i]
+		actual[m.Key] = m
+		keys = append(keys, m.Key)
+	}
+	for k := range desired {
+		if _, ok := actual[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	actions := make([]Action, 0)
+	var errs MultiError
+	for _, k := range keys {
+		if err := checkContext(ctx); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		want, isDesired := desired[k]
+		m, held := actual[k]
+		var act *Action
+		var aerr error
+		switch {
+		case isDesired && !held:
+			if _, err := kvPutLease(c, ctx, leaseID, k, want); err == nil {
+				act = &Action{Kind: ActionClaimed, Key: k, Value: want}
+			} else if err != PutSucceededFailure {
+				aerr = err
+			}
+		case !isDesired:
+			ok, err := putIfUnchanged(c, ctx, leaseID, k, m, nil)
+			if ok {
+				act = &Action{Kind: ActionReleased, Key: k, Value: m.Value}
+			}
+			aerr = err
+		case m.Value == want:
+			// already converged
+		case ro.TakeOver:
+			ok, err := putIfUnchanged(c, ctx, leaseID, k, m, &want)
+			if ok {
+				act = &Action{Kind: ActionTookOver, Key: k, Value: want}
+			}
+			aerr = err
+		default:
+			act = &Action{Kind: ActionConflict, Key: k, Value: want}
+		}
+		if act != nil {
+			actions = append(actions, *act)
+		}
+		if aerr != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", k, authError(aerr)))
+		}
+	}
+	if len(errs) > 0 {
+		return actions, errs
+	}
+	return actions, nil
+}
+
+// putIfUnchanged puts the value under the lease, or deletes the key if
+// value is nil, only if the key is unchanged since it was read as m.
+func putIfUnchanged(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	key string, m *Member, value *string) (bool, error) {
+	op := clientv3.OpDelete(key)
+	if value != nil {
+		op = clientv3.OpPut(key, *value, clientv3.WithLease(leaseID))
+	}
+	resp, err := c.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", m.CreateRevision),
+			clientv3.Compare(clientv3.Value(key), "=", m.Value),
+			clientv3.Compare(clientv3.LeaseValue(key), "=", m.LeaseID)).
+		Then(op).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
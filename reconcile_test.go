@@ -0,0 +1,58 @@
+package stonecutters
+
+import (
+	"context"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	prefix := "/reconcile/"
+	ids := PrefixedNumerics(prefix, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	// 0 matches, 1 is held by someone else, 3 isn't desired
+	for i, v := range map[int]string{0: "worker-a", 1: "worker-x", 3: "worker-d"} {
+		if _, err := kvPutLease(client, ctx, lease.ID, ids[i], v); err != nil {
+			t.Fatalf("error claiming %s: %v", ids[i], err)
+		}
+	}
+	desired := map[string]string{ids[0]: "worker-a", ids[1]: "worker-b", ids[2]: "worker-c"}
+
+	actions, err := Reconcile(client, ctx, lease.ID, prefix, desired, ReconcileOptions{})
+	if err != nil {
+		t.Fatalf("Reconcile err: %v", err)
+	}
+	want := []Action{
+		{Kind: ActionConflict, Key: ids[1], Value: "worker-b"},
+		{Kind: ActionClaimed, Key: ids[2], Value: "worker-c"},
+		{Kind: ActionReleased, Key: ids[3], Value: "worker-d"},
+	}
+	if len(actions) != len(want) {
+		t.Fatalf("actions should be %v; not: %v", want, actions)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Errorf("action %d should be %v; not: %v", i, want[i], actions[i])
+		}
+	}
+
+	actions, err = Reconcile(client, ctx, lease.ID, prefix, desired, ReconcileOptions{TakeOver: true})
+	if err != nil {
+		t.Fatalf("Reconcile err: %v", err)
+	}
+	if len(actions) != 1 || actions[0].Kind != ActionTookOver || actions[0].Key != ids[1] {
+		t.Errorf("only %s should be taken over; not: %v", ids[1], actions)
+	}
+
+	// converged
+	if actions, err := Reconcile(client, ctx, lease.ID, prefix, desired, ReconcileOptions{}); err != nil || len(actions) != 0 {
+		t.Errorf("converged pool should need no actions: %v %v", actions, err)
+	}
+}
@@ -0,0 +1,149 @@
+package stonecutters
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+var tombstonePrefix = "tombstone/"
+
+// Release deletes the key regardless of who holds it. Prefer ReleaseIf
+// unless the caller is sure the key is still its own.
+//
+// Honours WithAuditSink, recording whoever held the key.
+func Release(c *clientv3.Client, ctx context.Context, key string, opts ...Option) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	o := newOptions(opts)
+	if o.audit == nil {
+		_, err := c.Delete(ctx, key)
+		return err
+	}
+	resp, err := c.Delete(ctx, key, clientv3.WithPrevKV())
+	if err != nil {
+		return err
+	}
+	for _, kv := range resp.PrevKvs {
+		o.audited(AuditReleased, &Member{Key: key, Value: string(kv.Value), LeaseID: clientv3.LeaseID(kv.Lease)})
+	}
+	return nil
+}
+
+// ReleaseIf deletes the key only if it still holds the expected value under
+// the given lease, so a worker which silently lost its claim can't clobber
+// whoever claimed it next. Returns false if the key no longer matched.
+//
+// Honours WithTombstone and WithAuditSink.
+func ReleaseIf(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	key, expectedValue string, opts ...Option) (bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return false, err
+	}
+	o := newOptions(opts)
+	ops := []clientv3.Op{clientv3.OpDelete(key)}
+	var tombLease clientv3.LeaseID
+	if o.tombstone {
+		var putOpts []clientv3.OpOption
+		if o.tombstoneTTL > 0 {
+			lease, err := grantLease(c, ctx, o.tombstoneTTL)
+			if err != nil {
+				return false, err
+			}
+			tombLease = lease.ID
+			putOpts = append(putOpts, clientv3.WithLease(tombLease))
+		}
+		value := fmt.Sprintf("released-by:%s@%s", holderName(expectedValue),
+			o.clock.Now().UTC().Format(time.RFC3339))
+		ops = append(ops, clientv3.OpPut(TombstoneKey(key), value, putOpts...))
+	}
+	resp, err := c.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(key), "=", expectedValue),
+			clientv3.Compare(clientv3.LeaseValue(key), "=", leaseID)).
+		Then(ops...).
+		Commit()
+	if tombLease != clientv3.NoLease && (err != nil || !resp.Succeeded) {
+		abandonLease(c, tombLease)
+	}
+	if err != nil {
+		return false, err
+	}
+	if resp.Succeeded {
+		o.audited(AuditReleased, &Member{Key: key, Value: expectedValue, LeaseID: leaseID})
+	}
+	return resp.Succeeded, nil
+}
+
+// WithTombstone makes ReleaseIf leave a record of the release under the
+// key's TombstoneKey, "released-by:<holder>@<time>", written in the same Txn
+// as the delete. Observers can then tell a clean release from a lease which
+// expired, which leaves no tombstone. The tombstone expires after ttl
+// seconds, or persists until deleted if zero; it never blocks the id being
+// claimed again. A later release of the id replaces it.
+func WithTombstone(ttl int64) Option {
+	return func(o *options) {
+		o.tombstone, o.tombstoneTTL = true, ttl
+	}
+}
+
+// TombstoneKey returns the key the tombstone of key is written to. It is
+// kept out of the pool's own range, where it would mark the id claimed.
+func TombstoneKey(key string) string {
+	return tombstonePrefix + key
+}
+
+// Tombstone is the record of a release left by WithTombstone.
+type Tombstone struct {
+	Holder     string // name of the holder which released the key
+	ReleasedAt time.Time
+}
+
+// ReadTombstone returns the tombstone of key, or nil if it has none: it has
+// never been released with WithTombstone, or the tombstone has expired.
+func ReadTombstone(c *clientv3.Client, ctx context.Context, key string) (*Tombstone, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	got, err := c.Get(ctx, TombstoneKey(key))
+	if err != nil {
+		return nil, authError(err)
+	}
+	if len(got.Kvs) == 0 {
+		return nil, nil
+	}
+	value := strings.TrimPrefix(string(got.Kvs[0].Value), "released-by:")
+	i := strings.LastIndexByte(value, '@')
+	if i < 0 {
+		return nil, fmt.Errorf("lock: invalid tombstone %q", got.Kvs[0].Value)
+	}
+	at, err := time.Parse(time.RFC3339, value[i+1:])
+	if err != nil {
+		return nil, fmt.Errorf("lock: invalid tombstone %q: %v", got.Kvs[0].Value, err)
+	}
+	return &Tombstone{Holder: value[:i], ReleasedAt: at}, nil
+}
+
+// holderName returns the name of the holder a claim value records.
+func holderName(value string) string {
+	if ident, err := DecodeIdentity(value); err == nil && ident.Name != "" {
+		return ident.Name
+	}
+	return value
+}
+
+// Revoke revokes the lease, releasing every key claimed under it.
+func Revoke(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+	return revokeLease(c, ctx, leaseID)
+}
+
+func revokeLease(lease clientv3.Lease, ctx context.Context, leaseID clientv3.LeaseID) error {
+	_, err := lease.Revoke(ctx, leaseID)
+	return err
+}
@@ -0,0 +1,118 @@
+package stonecutters
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReleaseIf(t *testing.T) {
+	ids := []string{"release-if"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer Release(client, ctx, ids[0])
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	other, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, other.ID)
+
+	mem, err := Join(client, ctx, lease.ID, "hihi", ids)
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+
+	released, err := ReleaseIf(client, ctx, lease.ID, mem.Key, "someone-else")
+	if err != nil || released {
+		t.Errorf("release with a mismatched value should not delete: %v %v", released, err)
+	}
+	released, err = ReleaseIf(client, ctx, other.ID, mem.Key, mem.Value)
+	if err != nil || released {
+		t.Errorf("release with a mismatched lease should not delete: %v %v", released, err)
+	}
+	if !verifyKvPair(client, mem.Key, mem.Value) {
+		t.Errorf("%s should still be held by %s", mem.Key, mem.Value)
+	}
+
+	released, err = ReleaseIf(client, ctx, lease.ID, mem.Key, mem.Value)
+	if err != nil || !released {
+		t.Errorf("release by the holder should delete: %v %v", released, err)
+	}
+	got, err := client.Get(ctx, mem.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Kvs) > 0 {
+		t.Errorf("no key should remain %s: %s", mem.Key, string(got.Kvs[0].Value))
+	}
+}
+
+func TestReleaseTombstone(t *testing.T) {
+	ids := PrefixedNumerics("/tombstone/", 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	persistent, err := JoinAs(client, ctx, lease.ID, NewIdentity("hihi"), ids)
+	if err != nil {
+		t.Fatalf("JoinAs err: %v", err)
+	}
+	short, err := Join(client, ctx, lease.ID, "hihi-short", ids)
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	defer client.Delete(ctx, TombstoneKey(persistent.Key))
+
+	before := time.Now().Add(-time.Second)
+	if ok, err := ReleaseIf(client, ctx, lease.ID, persistent.Key, persistent.Value, WithTombstone(0)); err != nil || !ok {
+		t.Fatalf("ReleaseIf should release %s; ok[%v] err[%v]", persistent.Key, ok, err)
+	}
+	if ok, err := ReleaseIf(client, ctx, lease.ID, short.Key, short.Value, WithTombstone(1)); err != nil || !ok {
+		t.Fatalf("ReleaseIf should release %s; ok[%v] err[%v]", short.Key, ok, err)
+	}
+
+	ts, err := ReadTombstone(client, ctx, persistent.Key)
+	if err != nil {
+		t.Fatalf("ReadTombstone err: %v", err)
+	}
+	if ts == nil || ts.Holder != "hihi" || ts.ReleasedAt.Before(before) {
+		t.Errorf("tombstone should record hihi's release: %+v", ts)
+	}
+	// the tombstone doesn't stop the id being claimed again
+	if m, err := Join(client, ctx, lease.ID, "hihi-next", ids[:1]); err != nil || m.Key != persistent.Key {
+		t.Errorf("released id should be claimable: %v %v", m, err)
+	}
+
+	time.Sleep(3 * time.Second)
+	if ts, err := ReadTombstone(client, ctx, short.Key); err != nil || ts != nil {
+		t.Errorf("short tombstone should have expired: %+v %v", ts, err)
+	}
+	if ts, err := ReadTombstone(client, ctx, persistent.Key); err != nil || ts == nil {
+		t.Errorf("persistent tombstone should remain: %+v %v", ts, err)
+	}
+
+	// a lease expiring leaves none
+	expired, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	m, err := Join(client, ctx, expired.ID, "hihi", ids)
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	expireLease(t, expired.ID)
+	if ts, err := ReadTombstone(client, ctx, m.Key); err != nil || ts != nil {
+		t.Errorf("expiry should leave no tombstone: %+v %v", ts, err)
+	}
+}
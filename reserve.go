@@ -0,0 +1,52 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+var ReservationExpiredFailure = errors.New("lock: reservation expired before it was activated")
+
+// Reserve claims one of the ids, as Join does, under a lease of its own
+// lasting 'window' seconds and never renewed: a short hold on the id while
+// the caller sets up its work. Activate binds it to the caller's real lease
+// within the window; otherwise etcd frees the id when the window passes.
+// The returned Member's LeaseID is the reservation's lease.
+//
+// Honours the options of Join.
+func Reserve(c *clientv3.Client, ctx context.Context, name string, ids []string,
+	window int64, opts ...Option) (*Member, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	lease, err := grantLease(c, ctx, window)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Join(c, ctx, lease.ID, name, ids, opts...)
+	if err != nil {
+		abandonLease(c, lease.ID)
+		return nil, err
+	}
+	return m, nil
+}
+
+// Activate moves a reservation made with Reserve to leaseID, in one Txn so
+// the id is never free in between, then revokes the reservation's lease.
+// Returns ReservationExpiredFailure if the window passed first and the id
+// was freed.
+func Activate(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	reservation *Member) (*Member, error) {
+	m, err := Transfer(c, ctx, reservation.LeaseID, reservation.Value, reservation.Key, leaseID, reservation.Value)
+	if err == TransferFailure {
+		return nil, ReservationExpiredFailure
+	} else if err != nil {
+		return nil, err
+	}
+	if err := revokeLease(c, ctx, reservation.LeaseID); err != nil {
+		return m, authError(err)
+	}
+	return m, nil
+}
@@ -0,0 +1,59 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestReserveActivate(t *testing.T) {
+	ids := PrefixedNumerics("/reserve/", 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(30))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	res, err := Reserve(client, ctx, "hihi", ids, 2)
+	if err != nil {
+		t.Fatalf("Reserve err: %v", err)
+	}
+	if res.Key != ids[0] || res.LeaseID == lease.ID {
+		t.Fatalf("reservation should hold %s under its own lease: %v", ids[0], res)
+	}
+	// the reserved id can't be taken while work is set up
+	if m, err := Join(client, ctx, lease.ID, "hihi-other", ids[:1]); !errors.Is(err, GetIdFailure) {
+		t.Errorf("reserved id should not be claimable: %v %v", m, err)
+	}
+
+	m, err := Activate(client, ctx, lease.ID, res)
+	if err != nil {
+		t.Fatalf("Activate err: %v", err)
+	}
+	got, err := client.Get(ctx, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Key != ids[0] || clientv3.LeaseID(got.Kvs[0].Lease) != lease.ID {
+		t.Errorf("activated %s should be bound to the real lease", ids[0])
+	}
+
+	// a reservation left to lapse frees the id
+	res, err = Reserve(client, ctx, "hihi", ids, 1)
+	if err != nil {
+		t.Fatalf("Reserve err: %v", err)
+	}
+	time.Sleep(2500 * time.Millisecond)
+	if _, err := Activate(client, ctx, lease.ID, res); err != ReservationExpiredFailure {
+		t.Errorf("err[%v] should be ReservationExpiredFailure", err)
+	}
+	if got, err := client.Get(ctx, res.Key); err != nil || len(got.Kvs) != 0 {
+		t.Errorf("lapsed reservation %s should be free: %v %v", res.Key, got, err)
+	}
+}
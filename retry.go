@@ -0,0 +1,123 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+var (
+	defaultRetryBase = 100 * time.Millisecond
+	defaultRetryMax  = 10 * time.Second
+
+	jitter   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterMu sync.Mutex
+)
+
+// BackoffPolicy decides how long JoinRetry waits between attempts.
+type BackoffPolicy interface {
+	// Next returns the delay before retry number 'attempt' (starting at 1)
+	// given the previous delay, which is zero before the first retry.
+	Next(attempt int, prev time.Duration) time.Duration
+}
+
+// ConstantBackoff waits the same Delay between every attempt.
+type ConstantBackoff struct {
+	Delay time.Duration
+}
+
+func (b ConstantBackoff) Next(attempt int, prev time.Duration) time.Duration {
+	return b.Delay
+}
+
+// ExponentialBackoff doubles the delay from Base on each attempt, capped at Max.
+type ExponentialBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b ExponentialBackoff) Next(attempt int, prev time.Duration) time.Duration {
+	d := b.Base
+	for i := 1; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	return d
+}
+
+// DecorrelatedJitterBackoff picks each delay at random between Base and
+// three times the previous delay, capped at Max. Claimants that collided
+// once spread out rather than retrying in lockstep as they do with plain
+// exponential backoff.
+type DecorrelatedJitterBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b DecorrelatedJitterBackoff) Next(attempt int, prev time.Duration) time.Duration {
+	if prev < b.Base {
+		prev = b.Base
+	}
+	d := b.Base
+	if span := int64(prev*3 - b.Base); span > 0 {
+		jitterMu.Lock()
+		d += time.Duration(jitter.Int63n(span))
+		jitterMu.Unlock()
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	return d
+}
+
+// RetryOptions configures JoinRetry.
+type RetryOptions struct {
+	// Attempts caps the number of Join calls; zero retries until the
+	// context is closed.
+	Attempts int
+	// Policy defaults to DecorrelatedJitterBackoff from 100ms up to 10s.
+	Policy BackoffPolicy
+}
+
+// JoinRetry calls Join until an id is granted, waiting between attempts as
+// decided by the options Policy while every id is claimed. Errors other
+// than GetIdFailure are returned without retrying. The options are passed
+// on to each Join.
+func JoinRetry(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	name string, ids []string, ro RetryOptions, opts ...Option) (*Member, error) {
+	policy := ro.Policy
+	if policy == nil {
+		policy = DecorrelatedJitterBackoff{Base: defaultRetryBase, Max: defaultRetryMax}
+	}
+
+	o := newOptions(opts)
+	start := o.clock.Now()
+	opts = append(opts[:len(opts):len(opts)], withoutMetrics())
+
+	var delay time.Duration
+	for attempt := 1; ; attempt++ {
+		m, err := Join(c, ctx, leaseID, name, ids, opts...)
+		if err == nil {
+			o.observeClaim(start)
+		}
+		if !errors.Is(err, GetIdFailure) {
+			return m, err
+		}
+		if ro.Attempts > 0 && attempt >= ro.Attempts {
+			return nil, err
+		}
+
+		delay = policy.Next(attempt, delay)
+		select {
+		case <-ctx.Done():
+			return nil, checkContext(ctx)
+		case <-o.clock.After(delay):
+		}
+	}
+}
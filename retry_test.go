@@ -0,0 +1,75 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoffPolicies(t *testing.T) {
+	base, max := 10*time.Millisecond, 100*time.Millisecond
+
+	c := ConstantBackoff{Delay: base}
+	if d := c.Next(5, max); d != base {
+		t.Errorf("constant delay should be %v; not: %v", base, d)
+	}
+
+	e := ExponentialBackoff{Base: base, Max: max}
+	want := []time.Duration{10, 20, 40, 80, 100, 100}
+	for i, w := range want {
+		if d := e.Next(i+1, 0); d != w*time.Millisecond {
+			t.Errorf("exponential attempt %d should be %v; not: %v", i+1, w*time.Millisecond, d)
+		}
+	}
+
+	j := DecorrelatedJitterBackoff{Base: base, Max: max}
+	var prev time.Duration
+	for i := 1; i <= 50; i++ {
+		d := j.Next(i, prev)
+		if d < base || d > max {
+			t.Errorf("jitter attempt %d delay %v outside [%v, %v]", i, d, base, max)
+		}
+		if p := prev; p >= base && d > p*3 {
+			t.Errorf("jitter attempt %d delay %v more than 3x previous %v", i, d, p)
+		}
+		prev = d
+	}
+}
+
+func TestJoinRetryAttempts(t *testing.T) {
+	ids := []string{"retry-a"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	if _, err := Join(client, ctx, lease.ID, "hihi", ids); err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+
+	start := time.Now()
+	ro := RetryOptions{Attempts: 3, Policy: ConstantBackoff{Delay: 50 * time.Millisecond}}
+	mem, err := JoinRetry(client, ctx, lease.ID, "hihi", ids, ro)
+	if !errors.Is(err, GetIdFailure) {
+		t.Errorf("err[%v] should be GetIdFailure", err)
+	}
+	if mem != nil {
+		t.Errorf("Member[%v] should not be granted an id!", *mem)
+	}
+	if el := time.Since(start); el < 100*time.Millisecond {
+		t.Errorf("3 attempts should have waited at least 100ms; waited %v", el)
+	}
+
+	// closed while backing off
+	rctx, rcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer rcancel()
+	ro = RetryOptions{Policy: ConstantBackoff{Delay: time.Second}}
+	if _, err := JoinRetry(client, rctx, lease.ID, "hihi", ids, ro); !errors.Is(err, ContextDoneFailure) {
+		t.Errorf("err[%v] should be ContextDoneFailure", err)
+	}
+}
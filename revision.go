@@ -0,0 +1,37 @@
+package stonecutters
+
+import (
+	"context"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// Revision returns etcd's current revision. The revision is a single
+// counter for the whole cluster which every write to any key increments,
+// so it never decreases and can be used as a cheap epoch or fencing token.
+// It also moves for writes outside of the pool.
+func Revision(c *clientv3.Client, ctx context.Context) (int64, error) {
+	resp, err := c.Get(ctx, "\x00", clientv3.WithCountOnly())
+	if err != nil {
+		return 0, err
+	}
+	return resp.Header.Revision, nil
+}
+
+// MaxCreateRevision returns the highest CreateRevision among the keys under
+// prefix; the revision at which the most recent of the current claims was
+// made, or zero if none are claimed. It only moves for pool changes, but
+// unlike Revision it can decrease: releasing the most recent claim falls
+// back to the next most recent.
+func MaxCreateRevision(c *clientv3.Client, ctx context.Context, prefix string) (int64, error) {
+	resp, err := c.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly(),
+		clientv3.WithSort(clientv3.SortByCreateRevision, clientv3.SortDescend),
+		clientv3.WithLimit(1))
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, nil
+	}
+	return resp.Kvs[0].CreateRevision, nil
+}
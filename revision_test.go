@@ -0,0 +1,45 @@
+package stonecutters
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRevisions(t *testing.T) {
+	prefix := "/revision/"
+	ids := PrefixedNumerics(prefix, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(5))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	if max, err := MaxCreateRevision(client, ctx, prefix); err != nil || max != 0 {
+		t.Errorf("empty pool should have max create revision 0: %d %v", max, err)
+	}
+
+	var last int64
+	for range ids {
+		before, err := Revision(client, ctx)
+		if err != nil {
+			t.Fatalf("error reading revision: %v", err)
+		}
+		if before < last {
+			t.Errorf("revision went backwards: %d < %d", before, last)
+		}
+		if _, err := Join(client, ctx, lease.ID, "hihi", ids); err != nil {
+			t.Fatalf("Join err: %v", err)
+		}
+		max, err := MaxCreateRevision(client, ctx, prefix)
+		if err != nil {
+			t.Fatalf("error reading max create revision: %v", err)
+		}
+		if max <= before {
+			t.Errorf("max create revision %d should be past the pre-claim revision %d", max, before)
+		}
+		last = max
+	}
+}
@@ -0,0 +1,188 @@
+package stonecutters
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+)
+
+// CountByLabel returns how many members under prefix carry each value of
+// the Identity label labelKey, eg members per region. Members without the
+// label, including plain-name values, are not counted.
+//
+// Honours WithSerializable.
+func CountByLabel(c *clientv3.Client, ctx context.Context, prefix, labelKey string, opts ...Option) (map[string]int, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	got, err := c.Get(ctx, prefix, newOptions(opts).readOpts(clientv3.WithPrefix())...)
+	if err != nil {
+		return nil, authError(err)
+	}
+	counts := make(map[string]int)
+	for _, kv := range got.Kvs {
+		ident, err := DecodeIdentity(string(kv.Value))
+		if err != nil {
+			continue
+		}
+		if v, ok := ident.Labels[labelKey]; ok {
+			counts[v]++
+		}
+	}
+	return counts, nil
+}
+
+// Roster is a consistent read of the members under a prefix.
+type Roster struct {
+	Count    int
+	Members  []Member // sorted by key
+	Revision int64    // the revision the read was made at
+}
+
+// ReadRoster returns the count of members under prefix, the members and the
+// revision they were read at, all from a single ranged Get. The revision
+// can resume a watch from exactly where the read left off.
+//
+// Honours WithSerializable and WithKeyTransform, whose inverse maps the keys
+// read back to ids; keys it rejects are not counted.
+func ReadRoster(c *clientv3.Client, ctx context.Context, prefix string, opts ...Option) (*Roster, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	got, err := c.Get(ctx, prefix, o.readOpts(clientv3.WithPrefix())...)
+	if err != nil {
+		return nil, authError(err)
+	}
+	r := &Roster{Members: make([]Member, 0, len(got.Kvs)), Revision: got.Header.Revision}
+	for _, kv := range got.Kvs {
+		var id string
+		if o.idFn != nil {
+			var ok bool
+			if id, ok = o.idFn(string(kv.Key)); !ok {
+				continue
+			}
+		}
+		r.Members = append(r.Members, Member{
+			Key:            string(kv.Key),
+			Value:          string(kv.Value),
+			LeaseID:        clientv3.LeaseID(kv.Lease),
+			CreateRevision: kv.CreateRevision,
+			ID:             id,
+		})
+	}
+	r.Count = len(r.Members)
+	return r, nil
+}
+
+// PoolReport is an aggregate snapshot of a pool, for shipping to a metrics
+// pipeline and graphing over time.
+type PoolReport struct {
+	Total    int            // ids seeded or claimed
+	Claimed  int            // ids held
+	Free     int            // seeded ids nobody holds
+	PerHost  map[string]int // ids held by each holder name, usually the host
+	At       time.Time      // when the report was read
+	Revision int64          // the revision the report was read at
+}
+
+// ClaimReport returns aggregate counts for the pool under prefix: its ids,
+// seeded with SeedPool or held, how many are claimed and free, and how many
+// each holder has. Holder names are taken from the Identity of values
+// which carry one. Both reads are ranges of one Txn, so the counts are of
+// a single revision.
+//
+// Honours WithSerializable.
+func ClaimReport(c *clientv3.Client, ctx context.Context, prefix string, opts ...Option) (*PoolReport, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	readOpts := o.readOpts(clientv3.WithPrefix())
+	resp, err := c.Txn(ctx).Then(
+		clientv3.OpGet(PoolSeedKey(prefix), append(readOpts, clientv3.WithKeysOnly())...),
+		clientv3.OpGet(prefix, readOpts...),
+	).Commit()
+	if err != nil {
+		return nil, authError(err)
+	}
+	seeds := resp.Responses[0].GetResponseRange().Kvs
+	held := resp.Responses[1].GetResponseRange().Kvs
+
+	r := &PoolReport{PerHost: make(map[string]int), At: o.clock.Now(), Revision: resp.Header.Revision}
+	seeded := make(map[string]bool, len(seeds))
+	for _, kv := range seeds {
+		seeded[strings.TrimPrefix(string(kv.Key), poolSeedPrefix)] = true
+	}
+	r.Total = len(seeded)
+	for _, kv := range held {
+		r.Claimed++
+		r.PerHost[holderName(string(kv.Value))]++
+		if !seeded[string(kv.Key)] {
+			r.Total++
+		}
+	}
+	r.Free = r.Total - r.Claimed
+	return r, nil
+}
+
+// defaultAtRisk is the fraction of its granted TTL below which a member's
+// remaining lease puts it at risk.
+var defaultAtRisk = 0.2
+
+// LeasedMember is a Member with the state of its lease.
+type LeasedMember struct {
+	Member
+	GrantedTTL int64         // seconds
+	Remaining  time.Duration // zero if the lease is gone or the key leaseless
+	// AtRisk marks a member whose lease has less than the threshold of its
+	// granted TTL remaining, or is gone: likely a holder whose keepalives
+	// are failing, about to lose its claim. Leaseless keys never are.
+	AtRisk bool
+}
+
+// MembersTTL returns the members of ids as MembersContext does, with the
+// remaining TTL of each one's lease. Members with less than 'threshold' of
+// their granted TTL remaining are flagged AtRisk; zero means 20%. Each
+// lease is looked up once however many members share it.
+//
+// Honours WithSerializable for the member reads.
+func MembersTTL(c *clientv3.Client, ctx context.Context, ids []string, threshold float64,
+	opts ...Option) ([]*LeasedMember, error) {
+	if threshold == 0 {
+		threshold = defaultAtRisk
+	}
+	members, err := MembersContext(c, ctx, ids, opts...)
+	if err != nil {
+		return nil, err
+	}
+	leases := make(map[clientv3.LeaseID]*clientv3.LeaseTimeToLiveResponse)
+	leased := make([]*LeasedMember, 0, len(members))
+	for _, m := range members {
+		lm := &LeasedMember{Member: *m}
+		leased = append(leased, lm)
+		if m.LeaseID == clientv3.NoLease {
+			continue
+		}
+		ttl, ok := leases[m.LeaseID]
+		if !ok {
+			var err error
+			ttl, err = c.TimeToLive(ctx, m.LeaseID)
+			if err != nil && err != rpctypes.ErrLeaseNotFound {
+				return nil, authError(err)
+			}
+			leases[m.LeaseID] = ttl
+		}
+		if ttl == nil || ttl.TTL <= 0 {
+			lm.AtRisk = true
+			continue
+		}
+		lm.GrantedTTL = ttl.GrantedTTL
+		lm.Remaining = time.Duration(ttl.TTL) * time.Second
+		lm.AtRisk = float64(ttl.TTL) < threshold*float64(ttl.GrantedTTL)
+	}
+	return leased, nil
+}
@@ -0,0 +1,190 @@
+package stonecutters
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestCountByLabel(t *testing.T) {
+	prefix := "/labels/"
+	ids := PrefixedNumerics(prefix, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	for _, region := range []string{"east", "west", "east"} {
+		ident := NewIdentity("hihi")
+		ident.Labels = map[string]string{"region": region}
+		if _, err := JoinAs(client, ctx, lease.ID, ident, ids); err != nil {
+			t.Fatalf("JoinAs err: %v", err)
+		}
+	}
+	if _, err := Join(client, ctx, lease.ID, "unlabelled", ids); err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+
+	counts, err := CountByLabel(client, ctx, prefix, "region")
+	if err != nil {
+		t.Fatalf("CountByLabel err: %v", err)
+	}
+	if len(counts) != 2 || counts["east"] != 2 || counts["west"] != 1 {
+		t.Errorf("counts should be east:2 west:1; not: %v", counts)
+	}
+}
+
+func TestReadRoster(t *testing.T) {
+	prefix := "/roster/"
+	ids := PrefixedNumerics(prefix, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	var last *Member
+	for range ids[:2] {
+		if last, err = Join(client, ctx, lease.ID, "hihi", ids); err != nil {
+			t.Fatalf("Join err: %v", err)
+		}
+	}
+
+	r, err := ReadRoster(client, ctx, prefix)
+	if err != nil {
+		t.Fatalf("ReadRoster err: %v", err)
+	}
+	if r.Count != 2 || len(r.Members) != 2 {
+		t.Fatalf("roster should hold 2 members; not: %#v", r)
+	}
+	for i, m := range r.Members {
+		if m.Key != ids[i] || m.LeaseID != lease.ID {
+			t.Errorf("member %d unexpected: %#v", i, m)
+		}
+	}
+	if r.Revision < last.CreateRevision {
+		t.Errorf("roster revision %d should be at least the last claim's %d", r.Revision, last.CreateRevision)
+	}
+}
+
+func TestClaimReport(t *testing.T) {
+	prefix := "/report/"
+	ids := PrefixedNumerics(prefix, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := SeedPool(client, ctx, ids); err != nil {
+		t.Fatalf("SeedPool err: %v", err)
+	}
+	defer client.Delete(ctx, PoolSeedKey(prefix), clientv3.WithPrefix())
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	if _, err := Join(client, ctx, lease.ID, "host-a", ids); err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	last, err := JoinAs(client, ctx, lease.ID, NewIdentity("host-a"), ids)
+	if err != nil {
+		t.Fatalf("JoinAs err: %v", err)
+	}
+	// held but never seeded
+	if _, err := Join(client, ctx, lease.ID, "host-b", []string{prefix + "extra"}); err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+
+	r, err := ClaimReport(client, ctx, prefix)
+	if err != nil {
+		t.Fatalf("ClaimReport err: %v", err)
+	}
+	if r.Total != 4 || r.Claimed != 3 || r.Free != 1 {
+		t.Errorf("report should count 4 ids, 3 claimed, 1 free; not: %+v", r)
+	}
+	if len(r.PerHost) != 2 || r.PerHost["host-a"] != 2 || r.PerHost["host-b"] != 1 {
+		t.Errorf("report should count host-a:2 host-b:1; not: %v", r.PerHost)
+	}
+	if r.Revision <= last.CreateRevision || r.At.IsZero() {
+		t.Errorf("report should be read after the claims: %+v", r)
+	}
+}
+
+func TestReadRosterSerializable(t *testing.T) {
+	prefix := "/serializable/"
+	ids := PrefixedNumerics(prefix, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	if _, err := Join(client, ctx, lease.ID, "hihi", ids); err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+
+	// a single member cluster is never stale
+	r, err := ReadRoster(client, ctx, prefix, WithSerializable())
+	if err != nil {
+		t.Fatalf("ReadRoster err: %v", err)
+	}
+	if r.Count != 1 {
+		t.Errorf("serializable roster should hold 1 member; not: %d", r.Count)
+	}
+	members, err := MembersContext(client, ctx, ids, WithSerializable())
+	if err != nil {
+		t.Fatalf("MembersContext err: %v", err)
+	}
+	if len(members) != 1 || members[0].Key != ids[0] {
+		t.Errorf("serializable members should be %s; not: %v", ids[0], members)
+	}
+}
+
+func TestMembersTTL(t *testing.T) {
+	ids := PrefixedNumerics("/atrisk/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	healthy, err := client.Grant(ctx, int64(30))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, healthy.ID)
+	failing, err := client.Grant(ctx, int64(3))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, failing.ID)
+	if _, err := Join(client, ctx, healthy.ID, "hihi-healthy", ids); err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if _, err := Join(client, ctx, failing.ID, "hihi-failing", ids); err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	// the failing holder's keepalives stop
+	time.Sleep(2 * time.Second)
+
+	members, err := MembersTTL(client, ctx, ids, 0.5)
+	if err != nil {
+		t.Fatalf("MembersTTL err: %v", err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("members returned should be 2; not: %d", len(members))
+	}
+	if m := members[0]; m.AtRisk || m.GrantedTTL != 30 || m.Remaining <= 20*time.Second {
+		t.Errorf("healthy member unexpected: %#v", m)
+	}
+	if m := members[1]; !m.AtRisk || m.Remaining > 2*time.Second {
+		t.Errorf("failing member should be at risk: %#v", m)
+	}
+}
@@ -0,0 +1,111 @@
+package stonecutters
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// selfTestTTL is the TTL, in seconds, of the lease SelfTest claims under,
+// kept alive for as long as the test runs.
+var selfTestTTL = defaultLeaseTTL
+
+// SelfTestFailure is an id SelfTest couldn't round-trip, and the step of
+// the round-trip which failed: "claim", "verify" or "release".
+type SelfTestFailure struct {
+	ID   string
+	Step string
+	Err  error
+}
+
+func (f *SelfTestFailure) Error() string {
+	return fmt.Sprintf("lock: self-test %s of %s: %v", f.Step, f.ID, f.Err)
+}
+
+func (f *SelfTestFailure) Unwrap() error {
+	return f.Err
+}
+
+// SelfTest claims and releases every id in turn, under a lease of its own,
+// checking each claim reads back as held by it and each release leaves the
+// id free. Meant for operators to run against a fresh cluster to validate
+// its configuration and permissions; an id somebody already holds fails its
+// claim.
+//
+// Returns the ids which failed, in order. An error is returned only if the
+// test couldn't run to the end: the lease couldn't be granted or the
+// context closed, in which case the failures so far are returned with it.
+// The lease is kept alive throughout, however long the pool takes to walk,
+// and revoked on return, freeing any claim left behind.
+func SelfTest(c *clientv3.Client, ctx context.Context, ids []string) ([]*SelfTestFailure, error) {
+	lease, err := grantLease(c, ctx, selfTestTTL)
+	if err != nil {
+		return nil, err
+	}
+	defer abandonLease(c, lease.ID)
+	kctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	keepalive, err := c.KeepAlive(kctx, lease.ID)
+	if err != nil {
+		return nil, authError(err)
+	}
+	go drainKeepAlive(kctx, keepalive, nil)
+
+	name := selfTestName()
+	failures := make([]*SelfTestFailure, 0)
+	for _, id := range ids {
+		if err := checkContext(ctx); err != nil {
+			return failures, err
+		}
+		if f := selfTestID(c, ctx, lease.ID, name, id); f != nil {
+			if err := checkContext(ctx); err != nil {
+				return failures, err
+			}
+			failures = append(failures, f)
+		}
+	}
+	return failures, nil
+}
+
+// selfTestID round-trips a claim of id, returning nil if it held up.
+func selfTestID(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	name, id string) *SelfTestFailure {
+	txn, err := kvPutLease(c, ctx, leaseID, id, name)
+	if err != nil {
+		return &SelfTestFailure{ID: id, Step: "claim", Err: authError(err)}
+	}
+	m := &Member{Key: id, Value: name, LeaseID: leaseID, CreateRevision: txn.Header.Revision}
+	if ok, err := ConfirmOwnership(c, ctx, m); err != nil || !ok {
+		if err == nil {
+			err = VerificationError
+		}
+		ReleaseIf(c, ctx, leaseID, id, name)
+		return &SelfTestFailure{ID: id, Step: "verify", Err: err}
+	}
+	if ok, err := ReleaseIf(c, ctx, leaseID, id, name); err != nil || !ok {
+		if err == nil {
+			err = ReleaseFailure
+		}
+		return &SelfTestFailure{ID: id, Step: "release", Err: authError(err)}
+	}
+	got, err := c.Get(ctx, id, clientv3.WithCountOnly())
+	if err != nil {
+		return &SelfTestFailure{ID: id, Step: "release", Err: authError(err)}
+	}
+	if got.Count != 0 {
+		return &SelfTestFailure{ID: id, Step: "release", Err: ReleaseFailure}
+	}
+	return nil
+}
+
+// selfTestName is the value SelfTest claims under, naming the host it ran
+// from for anyone who spots a claim left mid-test.
+func selfTestName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return "stonecutters-selftest@" + host
+}
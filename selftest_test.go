@@ -0,0 +1,81 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestSelfTest(t *testing.T) {
+	ids := PrefixedNumerics("/selftest/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	failures, err := SelfTest(client, ctx, ids)
+	if err != nil || len(failures) != 0 {
+		t.Fatalf("a fresh pool should pass: %v %v", failures, err)
+	}
+
+	// an id somebody holds fails its claim, the others still pass
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	if _, err := client.Put(ctx, ids[1], "hihi", clientv3.WithLease(lease.ID)); err != nil {
+		t.Fatal(err)
+	}
+	failures, err = SelfTest(client, ctx, ids)
+	if err != nil || len(failures) != 1 {
+		t.Fatalf("the held id alone should fail: %v %v", failures, err)
+	}
+	if f := failures[0]; f.ID != ids[1] || f.Step != "claim" || !errors.Is(f, PutSucceededFailure) {
+		t.Errorf("%s should fail its claim; not: %v", ids[1], f)
+	}
+	if got, err := client.Get(ctx, "/selftest/", clientv3.WithPrefix()); err != nil || got.Count != 1 {
+		t.Errorf("only the held id should remain: %v %v", got, err)
+	}
+
+	cancel()
+	if _, err := SelfTest(client, ctx, ids); !errors.Is(err, ContextDoneFailure) {
+		t.Errorf("a closed context should abort the test: %v", err)
+	}
+}
+
+// slowKV delays every Txn, to stretch out a walk of the pool.
+type slowKV struct {
+	clientv3.KV
+	delay time.Duration
+}
+
+func (kv slowKV) Txn(ctx context.Context) clientv3.Txn {
+	time.Sleep(kv.delay)
+	return kv.KV.Txn(ctx)
+}
+
+func TestSelfTestOutlastsTTL(t *testing.T) {
+	ids := PrefixedNumerics("/selftest-slow/", 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := clientv3.New(clientv3.Config{Endpoints: client.Endpoints(), DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer c.Close()
+	c.KV = slowKV{KV: c.KV, delay: 200 * time.Millisecond}
+	defer func(ttl int64) { selfTestTTL = ttl }(selfTestTTL)
+	selfTestTTL = 2
+
+	start := time.Now()
+	failures, err := SelfTest(c, ctx, ids)
+	if err != nil || len(failures) != 0 {
+		t.Fatalf("a pool slower to walk than the TTL should pass: %v %v", failures, err)
+	}
+	if took := time.Since(start); took < 3*time.Second {
+		t.Errorf("the walk should outlast the TTL; took %v", took)
+	}
+}
@@ -0,0 +1,600 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+)
+
+var (
+	defaultFailureBuffer     = 16
+	defaultTTLSampleInterval = time.Second
+	LeaseLostFailure         = errors.New("lock: lease expired before it was renewed")
+	SessionClosedFailure     = errors.New("lock: session is closed")
+)
+
+// Claim is an identifier held by a Session under its own lease.
+type Claim struct {
+	Member
+	TTL int64 // seconds granted by etcd
+
+	c     *clientv3.Client
+	clock clock
+	audit *options // of the Claim call, recording its release or loss
+	done  chan struct{}
+
+	smu        sync.Mutex
+	state      ClaimState
+	states     chan ClaimState
+	expires    time.Time // local deadline for the next successful renewal
+	generation int64     // CreateRevision of a reclaim, see Generation
+}
+
+// Done is closed once the claim is lost or released.
+func (cl *Claim) Done() <-chan struct{} {
+	return cl.done
+}
+
+// ClaimFailure reports a claim the Session failed to renew or verify. Lost
+// claims have been dropped from the Session and their Done channel closed;
+// otherwise the renewal is retried on the next tick.
+type ClaimFailure struct {
+	Key    string
+	Err    error
+	Lost   bool
+	Reason LostReason // why the claim was lost, or its key deleted
+}
+
+// Session holds any number of claims, each under its own lease, and keeps
+// them all alive from a single renewal loop rather than a keepalive stream
+// per claim. The loop ticks at a third of the shortest claim TTL, renewing
+// and verifying every claim on each tick.
+type Session struct {
+	c *clientv3.Client
+
+	mu          sync.Mutex
+	claims      map[string]*Claim
+	lastLatency time.Duration
+	failures    chan ClaimFailure
+	wake        chan struct{}
+	closing     bool           // set once the renewal loop has stopped
+	watchers    sync.WaitGroup // of the deletion watches, see WithDeletionWatch
+
+	clock      clock
+	ttlEvery   time.Duration
+	ttlOnce    sync.Once
+	ttlUpdates chan map[string]time.Duration
+	ttlDone    chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewSession starts a Session's renewal loop, which runs until Close.
+//
+// Honours WithTTLSampleInterval.
+func NewSession(c *clientv3.Client, opts ...Option) *Session {
+	o := newOptions(opts)
+	if o.ttlSample <= 0 {
+		o.ttlSample = defaultTTLSampleInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Session{
+		c:        c,
+		claims:   make(map[string]*Claim),
+		failures: make(chan ClaimFailure, defaultFailureBuffer),
+		wake:     make(chan struct{}, 1),
+		clock:    o.clock,
+		ttlEvery: o.ttlSample,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Claim grants a lease of ttl seconds and Joins the ids under it. The
+// lease is revoked if no id could be claimed.
+//
+// Honours WithTTLFunc, WithDeletionWatch and WithAuditSink along with the
+// options of Join; WithMinTTL is checked against the Grant.
+func (s *Session) Claim(ctx context.Context, name string, ids []string, ttl int64, opts ...Option) (*Claim, error) {
+	if s.ctx.Err() != nil {
+		return nil, SessionClosedFailure
+	}
+	o := newOptions(append([]Option{withClock(s.clock)}, opts...))
+	start := o.clock.Now()
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	m, lease, err := grantAndJoin(s.c, ctx, name, ids, ttl, o, append(opts[:len(opts):len(opts)], withoutMetrics()))
+	if err != nil {
+		return nil, err
+	}
+
+	latency := o.observeClaim(start)
+	s.mu.Lock()
+	if held, ok := s.claims[m.Key]; ok && held.member() == *m {
+		// the same claim again, eg a retry with WithIdempotencyKey
+		s.lastLatency = latency
+		s.mu.Unlock()
+		return held, nil
+	}
+	s.mu.Unlock()
+
+	cl := newClaim(m)
+	cl.c, cl.clock, cl.audit = s.c, s.clock, o
+	cl.TTL = lease.TTL
+	cl.renewed(lease.TTL)
+	cl.setState(ClaimClaimed)
+	s.mu.Lock()
+	s.claims[cl.Key] = cl
+	s.lastLatency = latency
+	watch := o.deletion != DeletionIgnore && !s.closing
+	if watch {
+		s.watchers.Add(1)
+	}
+	s.mu.Unlock()
+	if watch {
+		go s.watchDeleted(cl, o.deletion)
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return cl, nil
+}
+
+// Release revokes the claim's lease, deleting its key, and drops it from
+// the Session.
+func (s *Session) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	cl, ok := s.claims[key]
+	delete(s.claims, key)
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	close(cl.done)
+	cl.setState(ClaimReleased)
+	if _, err := s.c.Revoke(ctx, cl.LeaseID); err != nil {
+		return err
+	}
+	m := cl.member()
+	cl.audit.audited(AuditReleased, &m)
+	return nil
+}
+
+// Failures reports claims which failed to renew or verify. The channel is
+// buffered; reports are dropped if it fills, the claim's Done channel is
+// closed regardless. Closed once the Session is closed.
+func (s *Session) Failures() <-chan ClaimFailure {
+	return s.failures
+}
+
+// Close stops the renewal loop and revokes every claim still held.
+func (s *Session) Close() error {
+	s.cancel()
+	<-s.done
+	s.ttlOnce.Do(s.closeTTLUpdates) // no sampler may start once closed
+	if s.ttlDone != nil {
+		<-s.ttlDone
+	}
+
+	s.mu.Lock()
+	claims := s.claims
+	s.claims = make(map[string]*Claim)
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var err error
+	for _, cl := range claims {
+		close(cl.done)
+		cl.setState(ClaimReleased)
+		if _, rerr := s.c.Revoke(ctx, cl.LeaseID); rerr != nil {
+			err = rerr
+			continue
+		}
+		m := cl.member()
+		cl.audit.audited(AuditReleased, &m)
+	}
+	return err
+}
+
+func (s *Session) run() {
+	defer close(s.done)
+	defer close(s.failures)
+	defer func() {
+		s.mu.Lock()
+		s.closing = true
+		s.mu.Unlock()
+		s.watchers.Wait() // they report failures too
+	}()
+	for {
+		var tick <-chan time.Time
+		if d, ok := s.interval(); ok {
+			tick = s.clock.After(d)
+		}
+		select {
+		case <-s.ctx.Done():
+		case <-s.wake:
+		case <-tick:
+			s.renewAll()
+		}
+		if s.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// interval returns a third of the shortest held claim TTL, or false when
+// the Session holds nothing.
+func (s *Session) interval() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var min int64
+	for _, cl := range s.claims {
+		if min == 0 || cl.TTL < min {
+			min = cl.TTL
+		}
+	}
+	if min == 0 {
+		return 0, false
+	}
+	return time.Duration(min) * time.Second / 3, true
+}
+
+// renewAll keeps alive and verifies each claim in turn.
+func (s *Session) renewAll() {
+	s.mu.Lock()
+	claims := make([]*Claim, 0, len(s.claims))
+	for _, cl := range s.claims {
+		claims = append(claims, cl)
+	}
+	s.mu.Unlock()
+
+	// concurrently, so a slow or dead lease doesn't hold up the others
+	errs := make([]error, len(claims))
+	var wg sync.WaitGroup
+	for i, cl := range claims {
+		cl.setState(ClaimRenewing)
+		wg.Add(1)
+		go func(i int, cl *Claim) {
+			defer wg.Done()
+			errs[i] = s.renew(cl)
+		}(i, cl)
+	}
+	wg.Wait()
+
+	for i, cl := range claims {
+		err := errs[i]
+		if err == nil {
+			cl.setState(ClaimClaimed)
+			continue
+		}
+		f := ClaimFailure{Key: cl.Key, Err: err}
+		switch {
+		case err == VerificationError:
+			f.Lost, f.Reason = true, LostSuperseded
+		case err == rpctypes.ErrLeaseNotFound, err == LeaseLostFailure:
+			f.Lost, f.Reason = true, LostExpired
+		case s.clock.Now().After(cl.deadline()):
+			f.Err, f.Lost, f.Reason = LeaseLostFailure, true, LostExpired
+		}
+		if f.Lost {
+			s.drop(cl)
+		} else {
+			cl.setState(ClaimClaimed) // still held, retried next tick
+		}
+		select {
+		case s.failures <- f:
+		default:
+		}
+	}
+}
+
+func (s *Session) renew(cl *Claim) error {
+	ctx, cancel := context.WithTimeout(s.ctx, time.Duration(cl.TTL)*time.Second/3)
+	defer cancel()
+	resp, err := s.c.KeepAliveOnce(ctx, cl.LeaseID)
+	if err != nil {
+		return authError(err)
+	}
+	if keepAliveLost(resp) {
+		return LeaseLostFailure
+	}
+	cl.renewed(resp.TTL)
+	m := cl.member()
+	ok, err := ConfirmOwnership(s.c, ctx, &m)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return VerificationError
+	}
+	return nil
+}
+
+// ConfirmOwnership checks, as ConfirmOwnership does, that the claim on key
+// is still the Session's at the generation it was claimed at. The renewal
+// loop makes the same check on every tick and drops claims which fail it;
+// this is for checking on demand, eg before acting on the id. False if the
+// Session doesn't hold key.
+func (s *Session) ConfirmOwnership(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	cl, ok := s.claims[key]
+	s.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	m := cl.member()
+	return ConfirmOwnership(s.c, ctx, &m)
+}
+
+// drop removes a lost claim, revoking its lease in case it's still alive.
+func (s *Session) drop(cl *Claim) {
+	s.mu.Lock()
+	held := s.claims[cl.Key] == cl
+	if held {
+		delete(s.claims, cl.Key)
+	}
+	s.mu.Unlock()
+	if held {
+		close(cl.done)
+		cl.setState(ClaimLost)
+		s.c.Revoke(s.ctx, cl.LeaseID)
+		m := cl.member()
+		cl.audit.audited(AuditLost, &m)
+	}
+}
+
+// VerifyAll confirms every claim held by the Session still has its value
+// under its lease in etcd, reading them all in as few Txns as etcd's op
+// limit allows. The result holds an entry per claim so partial loss is
+// visible; it does not drop claims found lost, the renewal loop does that.
+func (s *Session) VerifyAll(ctx context.Context) (map[string]bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	claims := make([]*Claim, 0, len(s.claims))
+	for _, cl := range s.claims {
+		claims = append(claims, cl)
+	}
+	s.mu.Unlock()
+
+	keys := make([]string, 0, len(claims))
+	for _, cl := range claims {
+		keys = append(keys, cl.Key)
+	}
+	kvs, err := getKeys(s.c, ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[string]bool, len(claims))
+	for i, cl := range claims {
+		held[cl.Key] = kvs[i] != nil && string(kvs[i].Value) == cl.Value &&
+			clientv3.LeaseID(kvs[i].Lease) == cl.LeaseID
+	}
+	return held, nil
+}
+
+// grantAndJoin grants a lease of ttl seconds and Joins the ids under it,
+// revoking the lease if no id could be claimed. WithMinTTL is checked
+// against the Grant; with WithTTLFunc each id is Joined in turn under a
+// lease of its own TTL.
+func grantAndJoin(c *clientv3.Client, ctx context.Context, name string, ids []string,
+	ttl int64, o *options, opts []Option) (*Member, *clientv3.LeaseGrantResponse, error) {
+	if len(ids) == 0 {
+		return nil, nil, ErrPoolEmpty
+	}
+	batches := [][]string{ids}
+	if o.ttlFor != nil {
+		// each batch is a single id, too few for Join to order
+		if o.order != nil {
+			ids = o.order(ids)
+		}
+		batches = make([][]string, 0, len(ids))
+		for _, id := range ids {
+			if o.filter == nil || o.filter(id) {
+				batches = append(batches, []string{id})
+			}
+		}
+	}
+	if o.minTTLFraction > 0 {
+		// confirmed on each Grant, Join needn't look it up again
+		opts = append(opts[:len(opts):len(opts)], WithMinTTL(0, 0))
+	}
+
+	exhausted := &PoolExhaustedError{Size: len(ids), Filtered: len(ids) - len(batches)}
+	leases := make(map[int64]*clientv3.LeaseGrantResponse)
+	var kept clientv3.LeaseID
+	defer func() {
+		for _, lease := range leases {
+			if lease.ID != kept {
+				abandonLease(c, lease.ID)
+			}
+		}
+	}()
+	for _, batch := range batches {
+		requested := ttl
+		if o.ttlFor != nil {
+			if t := o.ttlFor(batch[0]); t > 0 {
+				requested = t
+			}
+		}
+		lease, ok := leases[requested]
+		if !ok {
+			var err error
+			if lease, err = grantLease(c, ctx, requested); err != nil {
+				return nil, nil, err
+			}
+			leases[requested] = lease
+			if o.minTTLFraction > 0 {
+				min := o.minTTLRequested
+				if min == 0 {
+					min = requested
+				}
+				if err := checkTTL(lease.TTL, min, o.minTTLFraction); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		m, err := Join(c, ctx, lease.ID, name, batch, opts...)
+		var pe *PoolExhaustedError
+		if errors.As(err, &pe) && o.ttlFor != nil {
+			exhausted.Conflicts += pe.Conflicts
+			exhausted.Filtered += pe.Filtered
+			exhausted.Failed += pe.Failed
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if m.LeaseID != lease.ID {
+			// an earlier claim, eg by WithIdempotencyKey, under its own lease
+			ttl, err := c.TimeToLive(ctx, m.LeaseID)
+			if err != nil {
+				return nil, nil, authError(err)
+			}
+			kept = m.LeaseID
+			return m, &clientv3.LeaseGrantResponse{ID: m.LeaseID, TTL: ttl.GrantedTTL}, nil
+		}
+		kept = lease.ID
+		return m, lease, nil
+	}
+	return nil, nil, exhausted
+}
+
+// Status returns the number of claims held by the Session and the latency
+// of the latest Claim, lease grant included.
+func (s *Session) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Status{Held: len(s.claims), LastClaimLatency: s.lastLatency}
+}
+
+// Leases returns the lease of every claim held by the Session.
+func (s *Session) Leases() []clientv3.LeaseID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	leases := make([]clientv3.LeaseID, 0, len(s.claims))
+	for _, cl := range s.claims {
+		leases = append(leases, cl.LeaseID)
+	}
+	return leases
+}
+
+// ClaimInfo describes a held claim as etcd currently sees it.
+type ClaimInfo struct {
+	Key       string
+	Value     string
+	LeaseID   clientv3.LeaseID
+	TTL       int64         // seconds originally granted
+	Remaining time.Duration // left on the lease; negative if etcd no longer has it
+}
+
+func (ci ClaimInfo) String() string {
+	return fmt.Sprintf("%s\tlease=%x\tttl=%ds\tremaining=%v\tvalue=%q",
+		ci.Key, int64(ci.LeaseID), ci.TTL, ci.Remaining, ci.Value)
+}
+
+// Dump looks up the remaining TTL of every claim held by the Session,
+// sorted by key, to correlate in-process state with what etcd shows.
+func (s *Session) Dump(ctx context.Context) ([]ClaimInfo, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	infos := make([]ClaimInfo, 0, len(s.claims))
+	for _, cl := range s.claims {
+		infos = append(infos, ClaimInfo{Key: cl.Key, Value: cl.Value, LeaseID: cl.LeaseID, TTL: cl.TTL})
+	}
+	s.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+
+	for i := range infos {
+		ttl, err := s.c.TimeToLive(ctx, infos[i].LeaseID)
+		if err == rpctypes.ErrLeaseNotFound {
+			infos[i].Remaining = -1
+			continue
+		} else if err != nil {
+			return nil, authError(err)
+		}
+		infos[i].Remaining = time.Duration(ttl.TTL) * time.Second
+	}
+	return infos, nil
+}
+
+// TTLUpdates samples the TTL left on every claim held by the Session, at
+// the interval set by WithTTLSampleInterval, for views of when each slot
+// will free up. Each sample maps the claim keys to their remaining TTL,
+// negative for a lease etcd no longer has. Only the latest sample is
+// kept for a slow receiver. Sampling starts on the first call, every call
+// returns the same channel, and it is closed once the Session is closed.
+func (s *Session) TTLUpdates() <-chan map[string]time.Duration {
+	s.ttlOnce.Do(func() {
+		if s.ctx.Err() != nil {
+			s.closeTTLUpdates()
+			return
+		}
+		s.ttlUpdates = make(chan map[string]time.Duration, 1)
+		s.ttlDone = make(chan struct{})
+		go s.sampleTTLs()
+	})
+	return s.ttlUpdates
+}
+
+// closeTTLUpdates leaves TTLUpdates a closed channel, for a Session closed
+// before sampling started.
+func (s *Session) closeTTLUpdates() {
+	s.ttlUpdates = make(chan map[string]time.Duration)
+	close(s.ttlUpdates)
+}
+
+func (s *Session) sampleTTLs() {
+	defer close(s.ttlDone)
+	defer close(s.ttlUpdates)
+	for {
+		if infos, err := s.Dump(s.ctx); err == nil {
+			sample := make(map[string]time.Duration, len(infos))
+			for _, ci := range infos {
+				sample[ci.Key] = ci.Remaining
+			}
+			select {
+			case <-s.ttlUpdates: // stale
+			default:
+			}
+			s.ttlUpdates <- sample
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.clock.After(s.ttlEvery):
+		}
+	}
+}
+
+// WriteDump writes the Dump to w, one claim per line.
+func (s *Session) WriteDump(ctx context.Context, w io.Writer) error {
+	infos, err := s.Dump(ctx)
+	if err != nil {
+		return err
+	}
+	for _, ci := range infos {
+		if _, err := fmt.Fprintln(w, ci); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package stonecutters
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionRenewal(t *testing.T) {
+	ids := PrefixedNumerics("/session/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSession(client)
+	defer s.Close()
+
+	short, err := s.Claim(ctx, "hihi", ids, 2)
+	if err != nil {
+		t.Fatalf("Claim err: %v", err)
+	}
+	long, err := s.Claim(ctx, "hihi", ids, 10)
+	if err != nil {
+		t.Fatalf("Claim err: %v", err)
+	}
+	if d, _ := s.interval(); d != time.Duration(short.TTL)*time.Second/3 {
+		t.Errorf("renewal interval should follow the shortest TTL; not: %v", d)
+	}
+
+	// outlive the short TTL several times over
+	time.Sleep(5 * time.Second)
+	for _, cl := range []*Claim{short, long} {
+		if !verifyKvPair(client, cl.Key, cl.Value) {
+			t.Errorf("%s should still be held after renewals", cl.Key)
+		}
+	}
+
+	// losing the lease out from under the session is reported
+	if _, err := client.Revoke(ctx, short.LeaseID); err != nil {
+		t.Fatalf("error revoking lease: %v", err)
+	}
+	select {
+	case f := <-s.Failures():
+		if f.Key != short.Key || !f.Lost {
+			t.Errorf("failure %#v should report %s lost", f, short.Key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for failure report")
+	}
+	select {
+	case <-short.Done():
+	default:
+		t.Errorf("lost claim %s should be done", short.Key)
+	}
+
+	if err := s.Release(ctx, long.Key); err != nil {
+		t.Errorf("error releasing %s: %v", long.Key, err)
+	}
+	members, err := Members(client, ids)
+	if err != nil {
+		t.Fatalf("error listing members: %v", err)
+	}
+	if len(members) != 0 {
+		t.Errorf("members returned should be 0; not: %d", len(members))
+	}
+}
+
+func TestSessionVerifyAll(t *testing.T) {
+	ids := PrefixedNumerics("/verifyall/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSession(client)
+	defer s.Close()
+
+	claims := make([]*Claim, 0)
+	for range ids {
+		cl, err := s.Claim(ctx, "hihi", ids, 10)
+		if err != nil {
+			t.Fatalf("Claim err: %v", err)
+		}
+		claims = append(claims, cl)
+	}
+
+	// someone deletes one claim behind the session's back
+	if _, err := client.Delete(ctx, claims[1].Key); err != nil {
+		t.Fatalf("error deleting %s: %v", claims[1].Key, err)
+	}
+	held, err := s.VerifyAll(ctx)
+	if err != nil {
+		t.Fatalf("VerifyAll err: %v", err)
+	}
+	if len(held) != len(claims) {
+		t.Fatalf("VerifyAll should report every claim; reported: %v", held)
+	}
+	for i, cl := range claims {
+		if held[cl.Key] != (i != 1) {
+			t.Errorf("%s held should be %v; not: %v", cl.Key, i != 1, held[cl.Key])
+		}
+	}
+}
+
+func TestSessionDump(t *testing.T) {
+	ids := PrefixedNumerics("/dump/", 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSession(client)
+	defer s.Close()
+	for range ids {
+		if _, err := s.Claim(ctx, "hihi", ids, 10); err != nil {
+			t.Fatalf("Claim err: %v", err)
+		}
+	}
+
+	leases := s.Leases()
+	if len(leases) != 2 || leases[0] == leases[1] {
+		t.Errorf("each claim should have its own lease: %v", leases)
+	}
+	infos, err := s.Dump(ctx)
+	if err != nil {
+		t.Fatalf("Dump err: %v", err)
+	}
+	for i, ci := range infos {
+		if ci.Key != ids[i] || ci.Remaining <= 0 || ci.Remaining > 10*time.Second {
+			t.Errorf("dump %d unexpected: %v", i, ci)
+		}
+	}
+
+	var b strings.Builder
+	if err := s.WriteDump(ctx, &b); err != nil {
+		t.Fatalf("WriteDump err: %v", err)
+	}
+	if lines := strings.Count(b.String(), "\n"); lines != 2 {
+		t.Errorf("dump should have a line per claim:\n%s", b.String())
+	}
+	t.Logf("dump:\n%s", b.String())
+}
+
+func TestSessionLeaseExpired(t *testing.T) {
+	ids := PrefixedNumerics("/expired/", 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSession(client)
+	defer s.Close()
+	cl, err := s.Claim(ctx, "hihi", ids, 3)
+	if err != nil {
+		t.Fatalf("Claim err: %v", err)
+	}
+
+	// the worker was paused past its TTL
+	expireLease(t, cl.LeaseID)
+
+	select {
+	case f := <-s.Failures():
+		if f.Key != cl.Key || !f.Lost {
+			t.Errorf("failure should report %s lost; not: %#v", cl.Key, f)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("session should report the expired claim")
+	}
+	select {
+	case <-cl.Done():
+	case <-time.After(time.Second):
+		t.Errorf("expired claim should be done")
+	}
+
+	lease, err := client.Grant(ctx, int64(5))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	mem, err := Join(client, ctx, lease.ID, "hihi-other", ids)
+	if err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if mem.Key != cl.Key {
+		t.Errorf("expired id %s should be claimable again; got: %s", cl.Key, mem.Key)
+	}
+}
+
+func TestSessionTTLFunc(t *testing.T) {
+	ids := PrefixedNumerics("/tiers/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSession(client)
+	defer s.Close()
+	before, err := client.Leases(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first id is reserved and outlasts the burst ids
+	tiers := WithTTLFunc(func(id string) int64 {
+		if id == ids[0] {
+			return 20
+		}
+		return 0
+	})
+	for i, want := range []int64{20, 5, 5} {
+		cl, err := s.Claim(ctx, "hihi", ids, 5, tiers)
+		if err != nil {
+			t.Fatalf("Claim err: %v", err)
+		}
+		if cl.Key != ids[i] || cl.TTL != want {
+			t.Errorf("claim %d should be %s with TTL %d; not: %s %d", i, ids[i], want, cl.Key, cl.TTL)
+		}
+	}
+
+	after, err := client.Leases(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(after.Leases) - len(before.Leases); n != len(ids) {
+		t.Errorf("only the claims' leases should remain; %d were added", n)
+	}
+}
+
+func TestSessionTTLFuncOrdering(t *testing.T) {
+	ids := PrefixedNumerics("/tiers-ordered/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSession(client)
+	defer s.Close()
+
+	// the ordering applies across ids, though each is Joined on its own
+	reversed := WithOrdering(func(ids []string) []string {
+		out := make([]string, len(ids))
+		for i, id := range ids {
+			out[len(ids)-1-i] = id
+		}
+		return out
+	})
+	tiers := WithTTLFunc(func(id string) int64 { return 0 })
+	for _, i := range []int{2, 1, 0} {
+		cl, err := s.Claim(ctx, "hihi", ids, 5, tiers, reversed)
+		if err != nil {
+			t.Fatalf("Claim err: %v", err)
+		}
+		if cl.Key != ids[i] {
+			t.Errorf("Claim should be assigned %s in reverse; not: %s", ids[i], cl.Key)
+		}
+	}
+}
+
+func TestSessionTTLUpdates(t *testing.T) {
+	ids := PrefixedNumerics("/ttlupdates/", 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSession(client, WithTTLSampleInterval(200*time.Millisecond))
+	updates := s.TTLUpdates()
+	cl, err := s.Claim(ctx, "hihi", ids, 5)
+	if err != nil {
+		t.Fatalf("Claim err: %v", err)
+	}
+
+	deadline := time.After(3 * time.Second)
+	for seen := 0; seen < 2; {
+		select {
+		case sample := <-updates:
+			if ttl, ok := sample[cl.Key]; ok {
+				if ttl <= 0 || ttl > 5*time.Second {
+					t.Errorf("remaining TTL of %s unexpected: %v", cl.Key, ttl)
+				}
+				seen++
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for TTL samples")
+		}
+	}
+
+	s.Close()
+	for range updates {
+	}
+
+	// sampling never started before the Session closed
+	closed := NewSession(client)
+	closed.Close()
+	select {
+	case _, ok := <-closed.TTLUpdates():
+		if ok {
+			t.Error("TTLUpdates of a closed Session should send nothing")
+		}
+	case <-time.After(time.Second):
+		t.Error("TTLUpdates of a closed Session should be closed")
+	}
+}
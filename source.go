@@ -0,0 +1,48 @@
+package stonecutters
+
+import (
+	"context"
+	"fmt"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// IDSource supplies the candidate ids GetID tries, so the ids of a pool can
+// come from outside stonecutters, eg a database or an IPAM system, and
+// change between calls. Candidates is consulted once per GetID and may
+// return different ids each time; the claims themselves are still made in
+// etcd, so two sources handing out the same id can't both claim it.
+type IDSource interface {
+	Candidates(ctx context.Context) ([]string, error)
+}
+
+// IDList is an IDSource of a fixed list of ids, tried in order; the ids
+// Join takes.
+type IDList []string
+
+func (l IDList) Candidates(ctx context.Context) ([]string, error) {
+	return l, nil
+}
+
+// IDSourceFunc adapts a function to an IDSource.
+type IDSourceFunc func(ctx context.Context) ([]string, error)
+
+func (f IDSourceFunc) Candidates(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// GetID claims one of the candidates src supplies, as Join does for a
+// slice. A failure of the source is returned wrapped, and claims nothing.
+//
+// Honours the options of Join.
+func GetID(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	name string, src IDSource, opts ...Option) (*Member, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	ids, err := src.Candidates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("lock: id source: %w", err)
+	}
+	return Join(c, ctx, leaseID, name, ids, opts...)
+}
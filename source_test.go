@@ -0,0 +1,51 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestGetID(t *testing.T) {
+	ids := PrefixedNumerics("/source/", 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lease, err := client.Grant(ctx, int64(10))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	m, err := GetID(client, ctx, lease.ID, "hihi", IDList(ids))
+	if err != nil {
+		t.Fatalf("GetID err: %v", err)
+	}
+	if m.Key != ids[0] {
+		t.Errorf("GetID should claim %s; not: %s", ids[0], m.Key)
+	}
+
+	// an external allocator handing out the last id
+	calls := 0
+	src := IDSourceFunc(func(ctx context.Context) ([]string, error) {
+		calls++
+		return ids[2:], nil
+	})
+	if m, err = GetID(client, ctx, lease.ID, "hihi", src); err != nil {
+		t.Fatalf("GetID err: %v", err)
+	}
+	if m.Key != ids[2] || calls != 1 {
+		t.Errorf("GetID should claim %s from one call: %s %d", ids[2], m.Key, calls)
+	}
+	if _, err := GetID(client, ctx, lease.ID, "hihi", src); !errors.Is(err, GetIdFailure) {
+		t.Errorf("err[%v] should be GetIdFailure once the source's ids are claimed", err)
+	}
+
+	down := errors.New("inventory unavailable")
+	failing := IDSourceFunc(func(ctx context.Context) ([]string, error) {
+		return nil, down
+	})
+	if _, err := GetID(client, ctx, lease.ID, "hihi", failing); !errors.Is(err, down) {
+		t.Errorf("err[%v] should wrap the source's error", err)
+	}
+}
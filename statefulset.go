@@ -0,0 +1,45 @@
+package stonecutters
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ParseOrdinal returns the ordinal of a StatefulSet pod from its hostname,
+// the number after the last dash of its first label: 3 for "web-3" or
+// "web-3.web.default.svc.cluster.local". Returns false for hostnames not
+// named that way.
+func ParseOrdinal(hostname string) (int, bool) {
+	name := hostname
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	i := strings.LastIndexByte(name, '-')
+	if i < 0 || i == len(name)-1 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(name[i+1:])
+	if err != nil || n < 0 || strings.HasPrefix(name[i+1:], "+") {
+		return 0, false
+	}
+	return n, true
+}
+
+// StatefulSetPool returns the pool of a StatefulSet of 'replicas' pods, the
+// ids prefix followed by each ordinal from 0 to replicas-1, ordered for the
+// pod of hostname: the id of its own ordinal first, then the ordinals after
+// it, wrapping around, so Join claims its own id when free and otherwise
+// borrows the next one. preferred is the id of its own ordinal. Should the
+// hostname not carry an ordinal, or one outside the replicas, the pool is
+// in ordinal order and preferred is empty.
+func StatefulSetPool(prefix string, replicas int, hostname string) (ids []string, preferred string) {
+	if replicas <= 0 {
+		return []string{}, ""
+	}
+	ids = RangePool(prefix, 0, replicas-1)
+	ordinal, ok := ParseOrdinal(hostname)
+	if !ok || ordinal >= replicas {
+		return ids, ""
+	}
+	return append(ids[ordinal:], ids[:ordinal]...), ids[ordinal]
+}
@@ -0,0 +1,40 @@
+package stonecutters
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOrdinal(t *testing.T) {
+	for host, want := range map[string]int{
+		"web-0":                                0,
+		"web-3":                                3,
+		"my-app-12.my-app.default.svc.cluster": 12,
+	} {
+		if n, ok := ParseOrdinal(host); !ok || n != want {
+			t.Errorf("ordinal of %q should be %d; not: %d %v", host, want, n, ok)
+		}
+	}
+	for _, host := range []string{"", "web", "web-", "web-x", "web-+1", "web-1x", "localhost"} {
+		if n, ok := ParseOrdinal(host); ok {
+			t.Errorf("%q should have no ordinal; got: %d", host, n)
+		}
+	}
+}
+
+func TestStatefulSetPool(t *testing.T) {
+	ids, preferred := StatefulSetPool("/sts/", 4, "web-2.web")
+	if preferred != "/sts/2" {
+		t.Errorf("preferred should be /sts/2; not: %q", preferred)
+	}
+	if got := strings.Join(ids, ","); got != "/sts/2,/sts/3,/sts/0,/sts/1" {
+		t.Errorf("pool should start at the pod's ordinal and wrap: %s", got)
+	}
+
+	for _, host := range []string{"laptop", "web-9"} {
+		ids, preferred = StatefulSetPool("/sts/", 4, host)
+		if preferred != "" || strings.Join(ids, ",") != "/sts/0,/sts/1,/sts/2,/sts/3" {
+			t.Errorf("%q should get the pool in order with no preference: %v %q", host, ids, preferred)
+		}
+	}
+}
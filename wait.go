@@ -0,0 +1,99 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+// AcquireID blocks until it claims the single id under the lease, watching
+// the key and retrying the claim the moment its holder releases it. Any
+// number of callers may wait on the same id; the claim Txn decides between
+// them and the losers go back to waiting. A watch which fails, eg on a
+// compaction, is started afresh. Returns promptly, with
+// ContextDoneFailure, once the context is closed.
+func AcquireID(c *clientv3.Client, ctx context.Context, leaseID clientv3.LeaseID,
+	name, id string) (*Member, error) {
+	for {
+		m, err := Join(c, ctx, leaseID, name, []string{id})
+		if !errors.Is(err, GetIdFailure) {
+			return m, err
+		}
+		got, err := c.Get(ctx, id)
+		if err != nil {
+			if cerr := checkContext(ctx); cerr != nil {
+				return nil, cerr
+			}
+			return nil, authError(err)
+		}
+		if len(got.Kvs) == 0 {
+			continue // freed since the claim
+		}
+		if err := waitDeleted(c, ctx, id, got.Header.Revision+1); err != nil {
+			if err := waitFailed(ctx, err); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// WaitForRelease blocks until id is free, returning at once if it already
+// is, otherwise watching its key until the holder deletes it or its lease
+// expires. Being free when WaitForRelease returns doesn't mean it still is
+// when the caller acts on it: another claimant may take it in between, so
+// claim it with Join and wait again should that fail. Returns
+// ContextDoneFailure once the context is closed.
+func WaitForRelease(c *clientv3.Client, ctx context.Context, id string) error {
+	for {
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+		got, err := c.Get(ctx, id, clientv3.WithKeysOnly())
+		if err != nil {
+			if cerr := checkContext(ctx); cerr != nil {
+				return cerr
+			}
+			return authError(err)
+		}
+		if len(got.Kvs) == 0 {
+			return nil
+		}
+		if err := waitDeleted(c, ctx, id, got.Header.Revision+1); err == nil {
+			return nil
+		} else if err := waitFailed(ctx, err); err != nil {
+			return err
+		}
+	}
+}
+
+// waitFailed returns the error ending a wait whose watch failed with err:
+// ContextDoneFailure or an auth error. Any other failure, eg a compaction
+// or leader change, returns nil to look again and watch afresh.
+func waitFailed(ctx context.Context, err error) error {
+	if cerr := checkContext(ctx); cerr != nil {
+		return cerr
+	}
+	if aerr := authError(err); aerr != err {
+		return aerr
+	}
+	return nil
+}
+
+// waitDeleted watches the key from rev until it is deleted.
+func waitDeleted(c *clientv3.Client, ctx context.Context, key string, rev int64) error {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for wresp := range c.Watch(wctx, key, clientv3.WithRev(rev), clientv3.WithFilterPut()) {
+		if err := wresp.Err(); err != nil {
+			return err
+		}
+		for _, ev := range wresp.Events {
+			if ev.Type == mvccpb.DELETE {
+				return nil
+			}
+		}
+	}
+	return checkContext(ctx)
+}
@@ -0,0 +1,169 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestAcquireID(t *testing.T) {
+	id := "/acquire/shard-3"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	holder, err := client.Grant(ctx, int64(30))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, holder.ID)
+	if _, err := kvPutLease(client, ctx, holder.ID, id, "hihi-holder"); err != nil {
+		t.Fatalf("error claiming %s: %v", id, err)
+	}
+
+	type result struct {
+		m   *Member
+		err error
+	}
+	results := make(chan result, 2)
+	for _, name := range []string{"hihi-a", "hihi-b"} {
+		lease, err := client.Grant(ctx, int64(30))
+		if err != nil {
+			t.Fatalf("error creating lease: %v", err)
+		}
+		defer client.Revoke(ctx, lease.ID)
+		go func(name string) {
+			wctx, wcancel := context.WithTimeout(ctx, 3*time.Second)
+			defer wcancel()
+			m, err := AcquireID(client, wctx, lease.ID, name, id)
+			results <- result{m, err}
+		}(name)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if _, err := client.Revoke(ctx, holder.ID); err != nil {
+		t.Fatalf("error revoking lease: %v", err)
+	}
+
+	// one waiter wins the id; the other keeps waiting until its context ends
+	var won, timedOut int
+	for i := 0; i < 2; i++ {
+		r := <-results
+		switch {
+		case r.err == nil && r.m.Key == id:
+			won++
+		case errors.Is(r.err, ContextDoneFailure):
+			timedOut++
+		default:
+			t.Errorf("unexpected AcquireID result: %v %v", r.m, r.err)
+		}
+	}
+	if won != 1 || timedOut != 1 {
+		t.Errorf("exactly one waiter should acquire %s: won %d, timed out %d", id, won, timedOut)
+	}
+}
+
+// compactedOnceWatcher ends the first 'failures' watches as if their
+// revision were compacted away, then watches as usual.
+type compactedOnceWatcher struct {
+	clientv3.Watcher
+	failures int64
+}
+
+func (w *compactedOnceWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	if atomic.AddInt64(&w.failures, -1) >= 0 {
+		return compactedWatcher{}.Watch(ctx, key, opts...)
+	}
+	return w.Watcher.Watch(ctx, key, opts...)
+}
+
+func TestAcquireIDWatchFailed(t *testing.T) {
+	id := "/acquire-compacted/shard-3"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a client of its own, whose first watch fails
+	c, err := clientv3.New(clientv3.Config{Endpoints: client.Endpoints(), DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer c.Close()
+	c.Watcher = &compactedOnceWatcher{Watcher: c.Watcher, failures: 1}
+
+	holder, err := client.Grant(ctx, int64(30))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, holder.ID)
+	if _, err := kvPutLease(client, ctx, holder.ID, id, "hihi-holder"); err != nil {
+		t.Fatalf("error claiming %s: %v", id, err)
+	}
+	lease, err := client.Grant(ctx, int64(30))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+
+	errs := make(chan error, 1)
+	go func() {
+		wctx, wcancel := context.WithTimeout(ctx, 3*time.Second)
+		defer wcancel()
+		_, err := AcquireID(c, wctx, lease.ID, "hihi", id)
+		errs <- err
+	}()
+	time.Sleep(500 * time.Millisecond)
+	if _, err := client.Revoke(ctx, holder.ID); err != nil {
+		t.Fatalf("error revoking lease: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("AcquireID should watch again after a failed watch: %v", err)
+	}
+}
+
+func TestWaitForRelease(t *testing.T) {
+	id := "/waitrelease/shard-1"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := WaitForRelease(client, ctx, id); err != nil {
+		t.Fatalf("a free id should return at once: %v", err)
+	}
+
+	lease, err := client.Grant(ctx, int64(30))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	if _, err := kvPutLease(client, ctx, lease.ID, id, "hihi-holder"); err != nil {
+		t.Fatalf("error claiming %s: %v", id, err)
+	}
+
+	wctx, wcancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer wcancel()
+	if err := WaitForRelease(client, wctx, id); !errors.Is(err, ContextDoneFailure) {
+		t.Errorf("a held id err[%v] should wait until the context ends", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- WaitForRelease(client, ctx, id) }()
+	time.Sleep(200 * time.Millisecond)
+	// the holder departs and someone else takes it straight away
+	if _, err := client.Revoke(ctx, lease.ID); err != nil {
+		t.Fatalf("error revoking lease: %v", err)
+	}
+	if _, err := client.Put(ctx, id, "hihi-other"); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Delete(ctx, id)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("WaitForRelease err: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("WaitForRelease should return once the holder departs")
+	}
+}
@@ -0,0 +1,276 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+var (
+	defaultSinkBuffer = 64
+	defaultDebounce   = 100 * time.Millisecond
+)
+
+var WatchClosedFailure = errors.New("lock: watch closed before its context")
+
+// EventType is the kind of membership change seen by WatchMembers.
+type EventType int
+
+const (
+	MemberJoined  EventType = iota // identifier claimed
+	MemberLeft                     // identifier released or its lease expired
+	MemberChanged                  // owner value of a claimed identifier rewritten
+	WatchFailed                    // watch ended by etcd; the last event sent
+)
+
+func (t EventType) String() string {
+	switch t {
+	case MemberJoined:
+		return "joined"
+	case MemberLeft:
+		return "left"
+	case MemberChanged:
+		return "changed"
+	case WatchFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// MemberEvent is a single membership change. For MemberLeft the Member
+// holds the owner value from before the delete. For WatchFailed there is no
+// Member, and Err says why the watch ended.
+type MemberEvent struct {
+	Type     EventType
+	Member   *Member
+	Revision int64
+	Err      error
+}
+
+// EventSink receives the events seen by WatchMembers, eg to bridge them onto
+// a message bus. Publish is retried until it returns nil or the watch
+// context is closed, so a sink sees every event at least once.
+type EventSink interface {
+	Publish(ctx context.Context, ev MemberEvent) error
+}
+
+// WithEventSink makes WatchMembers publish every event to the sink as well
+// as the returned channel. Up to 'buffer' events are held while the sink
+// catches up, after which the watch waits on the sink.
+func WithEventSink(sink EventSink, buffer int) Option {
+	return func(o *options) {
+		o.sink = sink
+		o.sinkBuffer = buffer
+	}
+}
+
+// WatchMembers streams membership changes to keys under prefix until the
+// context is closed, at which point the returned channel is closed. The
+// channel must be drained by the caller.
+//
+// If etcd ends the watch first, eg when the revision it had reached is
+// compacted away or the client is closed, a WatchFailed event carrying the
+// error is sent before the channel is closed. Changes after it are not
+// seen; watch again, reading the members afresh, to carry on.
+//
+// Honours WithEventSink.
+func WatchMembers(c *clientv3.Client, ctx context.Context, prefix string, opts ...Option) <-chan MemberEvent {
+	o := newOptions(opts)
+	events := make(chan MemberEvent)
+
+	var sunk chan MemberEvent
+	if o.sink != nil {
+		buf := o.sinkBuffer
+		if buf <= 0 {
+			buf = defaultSinkBuffer
+		}
+		sunk = make(chan MemberEvent, buf)
+		go publishEvents(ctx, o.sink, sunk, o.clock)
+	}
+
+	send := func(me MemberEvent) bool {
+		if sunk != nil {
+			select {
+			case sunk <- me:
+			case <-ctx.Done():
+				return false
+			}
+		}
+		select {
+		case events <- me:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	wc := c.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithPrevKV())
+	go func() {
+		defer close(events)
+		if sunk != nil {
+			defer close(sunk)
+		}
+		for wresp := range wc {
+			if err := wresp.Err(); err != nil {
+				send(MemberEvent{Type: WatchFailed, Revision: wresp.Header.Revision, Err: authError(err)})
+				return
+			}
+			for _, ev := range wresp.Events {
+				if !send(memberEvent(ev)) {
+					return
+				}
+			}
+		}
+		if ctx.Err() == nil {
+			send(MemberEvent{Type: WatchFailed, Err: WatchClosedFailure})
+		}
+	}()
+	return events
+}
+
+// memberEvent converts an etcd watch event into a MemberEvent.
+func memberEvent(ev *clientv3.Event) MemberEvent {
+	me := MemberEvent{Revision: ev.Kv.ModRevision}
+	switch {
+	case ev.Type == mvccpb.DELETE:
+		me.Type = MemberLeft
+		me.Member = &Member{Key: string(ev.Kv.Key)}
+		if ev.PrevKv != nil {
+			me.Member.Value = string(ev.PrevKv.Value)
+		}
+	case ev.IsCreate():
+		me.Type = MemberJoined
+		me.Member = &Member{Key: string(ev.Kv.Key), Value: string(ev.Kv.Value)}
+	default:
+		me.Type = MemberChanged
+		me.Member = &Member{Key: string(ev.Kv.Key), Value: string(ev.Kv.Value)}
+	}
+	return me
+}
+
+// publishEvents hands each buffered event to the sink, retrying failed
+// publishes until they succeed or the context is closed.
+func publishEvents(ctx context.Context, sink EventSink, events <-chan MemberEvent, clk clock) {
+	backoff := DecorrelatedJitterBackoff{Base: defaultRetryBase, Max: defaultRetryMax}
+	for ev := range events {
+		var delay time.Duration
+		for attempt := 1; sink.Publish(ctx, ev) != nil; attempt++ {
+			delay = backoff.Next(attempt, delay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-clk.After(delay):
+			}
+		}
+	}
+}
+
+// WithDebounce sets how long LiveMembers waits after a change for further
+// changes before pushing the roster.
+func WithDebounce(d time.Duration) Option {
+	return func(o *options) {
+		o.debounce = d
+	}
+}
+
+// LiveMembers pushes the full list of members under prefix, sorted by key,
+// each time it changes. Rapid changes within the debounce window (100ms
+// unless WithDebounce is given) are coalesced into one update, and a
+// consumer which falls behind only ever receives the latest list. The
+// channel is closed once the context is.
+//
+// Honours WithDebounce.
+func LiveMembers(c *clientv3.Client, ctx context.Context, prefix string, opts ...Option) <-chan []*Member {
+	o := newOptions(opts)
+	debounce := o.debounce
+	if debounce <= 0 {
+		debounce = defaultDebounce
+	}
+	out := make(chan []*Member, 1)
+	push := func(roster map[string]string) {
+		select {
+		case <-out: // replace an update the consumer hasn't read yet
+		default:
+		}
+		out <- sortedMembers(roster)
+	}
+
+	go func() {
+		defer close(out)
+		backoff := DecorrelatedJitterBackoff{Base: defaultRetryBase, Max: defaultRetryMax}
+		var delay time.Duration
+		for attempt := 1; ctx.Err() == nil; attempt++ {
+			// (re)start from a snapshot; the watch picks up right after it
+			resp, err := c.Get(ctx, prefix, clientv3.WithPrefix())
+			if err == nil {
+				roster := make(map[string]string, len(resp.Kvs))
+				for _, kv := range resp.Kvs {
+					roster[string(kv.Key)] = string(kv.Value)
+				}
+				push(roster)
+				// only a watch which got going resets the backoff
+				if watchRoster(c, ctx, prefix, resp.Header.Revision+1, debounce, o.clock, roster, push) {
+					attempt, delay = 0, 0
+					continue
+				}
+			}
+			delay = backoff.Next(attempt, delay)
+			select {
+			case <-ctx.Done():
+			case <-o.clock.After(delay):
+			}
+		}
+	}()
+	return out
+}
+
+// watchRoster applies changes to the roster, pushing it once each burst of
+// changes settles. Returns when the watch fails or the context is closed,
+// reporting if the watch delivered any response, its creation included,
+// before then.
+func watchRoster(c *clientv3.Client, ctx context.Context, prefix string, rev int64,
+	debounce time.Duration, clk clock, roster map[string]string, push func(map[string]string)) bool {
+	wc := c.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rev), clientv3.WithCreatedNotify())
+	var flush <-chan time.Time
+	var delivered bool
+	for {
+		select {
+		case <-ctx.Done():
+			return delivered
+		case <-flush:
+			push(roster)
+			flush = nil
+		case wresp, ok := <-wc:
+			if !ok || wresp.Err() != nil {
+				return delivered
+			}
+			delivered = true
+			if len(wresp.Events) == 0 {
+				continue // the watch's creation
+			}
+			for _, ev := range wresp.Events {
+				if ev.Type == mvccpb.DELETE {
+					delete(roster, string(ev.Kv.Key))
+				} else {
+					roster[string(ev.Kv.Key)] = string(ev.Kv.Value)
+				}
+			}
+			if flush == nil {
+				flush = clk.After(debounce)
+			}
+		}
+	}
+}
+
+func sortedMembers(roster map[string]string) []*Member {
+	members := make([]*Member, 0, len(roster))
+	for k, v := range roster {
+		members = append(members, &Member{Key: k, Value: v})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Key < members[j].Key })
+	return members
+}
@@ -0,0 +1,279 @@
+package stonecutters
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+)
+
+// flakySink fails its first publish, then records every event.
+type flakySink struct {
+	mu     sync.Mutex
+	failed bool
+	events []MemberEvent
+}
+
+func (s *flakySink) Publish(ctx context.Context, ev MemberEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.failed {
+		s.failed = true
+		return errors.New("broker unavailable")
+	}
+	s.events = append(s.events, ev)
+	return nil
+}
+
+func (s *flakySink) recorded() []MemberEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]MemberEvent(nil), s.events...)
+}
+
+func TestWatchMembers(t *testing.T) {
+	prefix := "/watch/"
+	ids := PrefixedNumerics(prefix, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sink := &flakySink{}
+	events := WatchMembers(client, ctx, prefix, WithEventSink(sink, 4))
+
+	lease, err := client.Grant(ctx, int64(5))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	if _, err := Join(client, ctx, lease.ID, "hihi", ids); err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+	if _, err := client.Revoke(ctx, lease.ID); err != nil {
+		t.Fatalf("error revoking lease: %v", err)
+	}
+
+	want := []EventType{MemberJoined, MemberLeft}
+	for _, w := range want {
+		select {
+		case ev := <-events:
+			if ev.Type != w || ev.Member.Key != ids[0] || ev.Member.Value != "hihi" {
+				t.Errorf("event %v %#v should be %v for %s", ev.Type, ev.Member, w, ids[0])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %v event", w)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.recorded()) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	got := sink.recorded()
+	if len(got) != len(want) {
+		t.Fatalf("sink should have %d events after retrying; has: %d", len(want), len(got))
+	}
+	for i, w := range want {
+		if got[i].Type != w {
+			t.Errorf("sink event %d should be %v; not: %v", i, w, got[i].Type)
+		}
+	}
+}
+
+// compactedWatcher ends every watch as if its revision were compacted away.
+type compactedWatcher struct {
+	clientv3.Watcher
+}
+
+func (w compactedWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	wc := make(chan clientv3.WatchResponse, 1)
+	wc <- clientv3.WatchResponse{CompactRevision: 5}
+	close(wc)
+	return wc
+}
+
+func TestWatchMembersFailed(t *testing.T) {
+	prefix := "/watch-failed/"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a client of its own, to close under the watch
+	c, err := clientv3.New(clientv3.Config{Endpoints: client.Endpoints(), DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer c.Close()
+
+	waitFailed := func(events <-chan MemberEvent, want error) {
+		var last MemberEvent
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					if last.Type != WatchFailed || !errors.Is(last.Err, want) {
+						t.Errorf("last event %v %v should be WatchFailed with %v", last.Type, last.Err, want)
+					}
+					return
+				}
+				last = ev
+			case <-timeout:
+				t.Fatalf("timed out waiting for the watch to fail with %v", want)
+			}
+		}
+	}
+
+	events := WatchMembers(c, ctx, prefix)
+	time.Sleep(200 * time.Millisecond)
+	c.Close()
+	waitFailed(events, WatchClosedFailure)
+
+	c.Watcher = compactedWatcher{c.Watcher}
+	waitFailed(WatchMembers(c, ctx, prefix), rpctypes.ErrCompacted)
+}
+
+func TestLiveMembers(t *testing.T) {
+	prefix := "/live/"
+	ids := PrefixedNumerics(prefix, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	live := LiveMembers(client, ctx, prefix, WithDebounce(200*time.Millisecond), withClock(newFakeClock()))
+	waitFor := func(n int) []*Member {
+		deadline := time.After(5 * time.Second)
+		for {
+			select {
+			case members := <-live:
+				if len(members) == n {
+					return members
+				}
+			case <-deadline:
+				t.Fatalf("timed out waiting for %d live members", n)
+			}
+		}
+	}
+	waitFor(0)
+
+	lease, err := client.Grant(ctx, int64(5))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := Join(client, ctx, lease.ID, "hihi", ids); err != nil {
+			t.Fatalf("Join err: %v", err)
+		}
+	}
+	members := waitFor(3)
+	for i, m := range members {
+		if m.Key != ids[i] {
+			t.Errorf("live member %d should be %s; not: %s", i, ids[i], m.Key)
+		}
+	}
+
+	if _, err := client.Revoke(ctx, lease.ID); err != nil {
+		t.Fatalf("error revoking lease: %v", err)
+	}
+	waitFor(0)
+
+	cancel()
+	select {
+	case _, ok := <-live:
+		if ok {
+			// drain a final pending update
+			_, ok = <-live
+		}
+		if ok {
+			t.Errorf("live members channel should close with the context")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("timed out waiting for live members channel to close")
+	}
+}
+
+// countingKV counts every Get.
+type countingKV struct {
+	clientv3.KV
+	gets int64
+}
+
+func (kv *countingKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	atomic.AddInt64(&kv.gets, 1)
+	return kv.KV.Get(ctx, key, opts...)
+}
+
+func TestLiveMembersBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a client of its own, whose every watch fails at once
+	c, err := clientv3.New(clientv3.Config{Endpoints: client.Endpoints(), DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+	defer c.Close()
+	kv := &countingKV{KV: c.KV}
+	c.KV = kv
+	c.Watcher = compactedWatcher{c.Watcher}
+
+	clk := newManualClock()
+	live := LiveMembers(c, ctx, "/live-backoff/", withClock(clk))
+	select {
+	case <-live:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the first roster")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if gets := atomic.LoadInt64(&kv.gets); gets != 1 {
+		t.Fatalf("a failing watch should back off before reading again; read %d times", gets)
+	}
+	clk.Advance(defaultRetryMax)
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&kv.gets) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("LiveMembers should read again once backed off")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLiveMembersDebounceClock(t *testing.T) {
+	prefix := "/live-debounce/"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := newManualClock()
+	live := LiveMembers(client, ctx, prefix, WithDebounce(time.Minute), withClock(clk))
+	select {
+	case <-live:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the first roster")
+	}
+
+	lease, err := client.Grant(ctx, int64(5))
+	if err != nil {
+		t.Fatalf("error creating lease: %v", err)
+	}
+	defer client.Revoke(ctx, lease.ID)
+	if _, err := Join(client, ctx, lease.ID, "hihi", PrefixedNumerics(prefix, 1)); err != nil {
+		t.Fatalf("Join err: %v", err)
+	}
+
+	// the change waits on the clock rather than the wall
+	select {
+	case members := <-live:
+		t.Fatalf("the roster should wait out the debounce; pushed: %v", members)
+	case <-time.After(500 * time.Millisecond):
+	}
+	clk.Advance(time.Minute)
+	select {
+	case members := <-live:
+		if len(members) != 1 {
+			t.Errorf("the roster should hold the claim; pushed: %v", members)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the roster should be pushed once the clock passes the debounce")
+	}
+}